@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gbc/pkg/gbc"
 
@@ -30,6 +31,14 @@ func Run() int {
 		showVersion  = flag.Bool("v", false, "show version")
 		debug        = flag.Bool("debug", false, "enable debug mode")
 		outputScreen = flag.String("test", "", "only CPU works and output screen map file")
+		wavPath      = flag.String("wav", "", "record audio to .wav file")
+		stemsPath    = flag.String("stems", "", "record each channel to ${stems}_ch1.wav ~ ${stems}_ch4.wav")
+		midiPath     = flag.String("midi", "", "record notes to .mid file")
+		serialDevice = flag.String("serial", "", "link port device: none, loopback, stdout, printer, tcp, relay, dmg07 (default: config)")
+		split        = flag.Bool("split", false, "play 2 ROMs(or the same ROM twice) side by side with link cable")
+		importPath   = flag.String("import", "", "import save data of other emulators or flash carts (.sav, .srm, .sa1) and exit")
+		exportPath   = flag.String("export", "", "export save data and exit")
+		footer       = flag.String("footer", gbc.Footer48, "RTC footer of exported save data: 48, 44 or none")
 	)
 
 	flag.Parse()
@@ -42,27 +51,46 @@ func Run() int {
 	romPath := flag.Arg(0)
 	cur, _ := os.Getwd()
 
+	gbc.Version = getVersion()
 	cpu := &gbc.CPU{}
 
 	romDir := filepath.Dir(romPath)
 
-	romData, err := readROM(romPath)
-	if err != nil {
+	if err := loadROM(cpu, romPath); err != nil {
 		fmt.Fprintf(os.Stderr, "ROM Error: %s\n", err)
 		return ExitCodeError
 	}
 
-	cpu.Cartridge.ParseCartridge(romData)
-	cpu.TransferROM(romData)
-
 	test := *outputScreen != ""
 	os.Chdir(cur)
+	if *importPath != "" || *exportPath != "" { // after chdir, so relative paths are resolved like the other path flags
+		return convertSave(cpu, romDir, *importPath, *exportPath, *footer)
+	}
+	if *split && !test {
+		if conflicts := splitConflicts(); len(conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "Split Error: %s can't be used with -split\n", strings.Join(conflicts, ", "))
+			return ExitCodeError
+		}
+		return runSplit(cpu, romPath, flag.Arg(1), cur)
+	}
 	cpu.Init(romDir, *debug, test)
 	defer func() {
 		os.Chdir(cur)
 		cpu.Exit()
 	}()
 
+	if *serialDevice != "" {
+		if err := cpu.SetSerialDevice(*serialDevice); err != nil {
+			fmt.Fprintf(os.Stderr, "Serial Error: %s\n", err)
+			return ExitCodeError
+		}
+	}
+
+	if err := record(cpu, *wavPath, *stemsPath, *midiPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Audio Error: %s\n", err)
+		return ExitCodeError
+	}
+
 	if test {
 		sec := 60
 		cpu.DebugExec(30*sec, *outputScreen)
@@ -91,12 +119,114 @@ func getVersion() string {
 	return version
 }
 
+// splitConflicts returns the flags which are set but don't work in split mode. Link port is used by the cable between players.
+func splitConflicts() []string {
+	conflicts := []string{}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "debug", "serial", "wav", "stems", "midi":
+			conflicts = append(conflicts, "-"+f.Name)
+		}
+	})
+	return conflicts
+}
+
+// runSplit runs player 1(${cpu}) and player 2 side by side. Player 2 plays ${romPath2}, or the same ROM if it's empty.
+func runSplit(cpu *gbc.CPU, romPath, romPath2, cur string) int {
+	if romPath2 == "" {
+		romPath2 = romPath
+	}
+	cpu2 := &gbc.CPU{Player: 2}
+	if err := loadROM(cpu2, romPath2); err != nil {
+		fmt.Fprintf(os.Stderr, "ROM Error: %s\n", err)
+		return ExitCodeError
+	}
+
+	cpu.Player = 1
+	cpu.Init(filepath.Dir(romPath), false, false)
+	cpu2.Init(filepath.Dir(romPath2), false, false)
+	game := gbc.NewSplit(cpu, cpu2)
+	defer func() {
+		os.Chdir(cur)
+		game.Exit()
+	}()
+
+	ebiten.SetWindowResizable(true)
+	ebiten.SetWindowTitle("Worldwide")
+	ebiten.SetWindowSize(160*2*2, 144*2)
+	if err := ebiten.RunGame(game); err != nil {
+		return ExitCodeError
+	}
+	return ExitCodeOK
+}
+
+// convertSave imports ${importPath} into save data of the ROM, or exports it to ${exportPath}
+func convertSave(cpu *gbc.CPU, romDir, importPath, exportPath, footer string) int {
+	if importPath != "" && exportPath != "" {
+		fmt.Fprintf(os.Stderr, "Save Error: -import and -export can't be used together\n")
+		return ExitCodeError
+	}
+
+	err := cpu.OpenSave(romDir)
+	if exportPath != "" && err != nil {
+		fmt.Fprintf(os.Stderr, "Save Error: %s\n", err)
+		return ExitCodeError
+	}
+
+	var report []string
+	if importPath != "" {
+		report, err = cpu.ImportSave(importPath)
+	} else {
+		report, err = cpu.ExportSave(exportPath, footer)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Save Error: %s\n", err)
+		return ExitCodeError
+	}
+	for _, line := range report {
+		fmt.Println(line)
+	}
+	return ExitCodeOK
+}
+
+func loadROM(cpu *gbc.CPU, romPath string) error {
+	romData, err := readROM(romPath)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Ext(romPath) == ".gbs" {
+		return cpu.LoadGBS(romData)
+	}
+	cpu.Cartridge.ParseCartridge(romData)
+	cpu.TransferROM(romData)
+	cpu.ROMName = strings.TrimSuffix(filepath.Base(romPath), filepath.Ext(romPath))
+	return nil
+}
+
+func record(cpu *gbc.CPU, wavPath, stemsPath, midiPath string) error {
+	if wavPath != "" {
+		if err := cpu.RecordAudio(wavPath); err != nil {
+			return err
+		}
+	}
+	if stemsPath != "" {
+		if err := cpu.RecordStems(stemsPath); err != nil {
+			return err
+		}
+	}
+	if midiPath != "" {
+		return cpu.RecordMIDI(midiPath)
+	}
+	return nil
+}
+
 func readROM(path string) ([]byte, error) {
 	if path == "" {
-		return []byte{}, errors.New("please type .gb or .gbc file path")
+		return []byte{}, errors.New("please type .gb, .gbc or .gbs file path")
 	}
-	if filepath.Ext(path) != ".gb" && filepath.Ext(path) != ".gbc" {
-		return []byte{}, errors.New("please type .gb or .gbc file")
+	if ext := filepath.Ext(path); ext != ".gb" && ext != ".gbc" && ext != ".gbs" {
+		return []byte{}, errors.New("please type .gb, .gbc or .gbs file")
 	}
 
 	bytes, err := ioutil.ReadFile(path)
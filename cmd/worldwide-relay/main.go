@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"gbc/pkg/serial"
+)
+
+const (
+	ExitCodeOK int = iota
+	ExitCodeError
+)
+
+func main() {
+	os.Exit(Run())
+}
+
+// Run relay server for link cable over the internet
+func Run() int {
+	addr := flag.String("addr", ":7777", "listening address")
+	flag.Parse()
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Relay Error: %s\n", err)
+		return ExitCodeError
+	}
+	log.Printf("worldwide-relay is listening on %s", l.Addr())
+
+	if err := serial.NewRelayServer().Serve(l); err != nil {
+		fmt.Fprintf(os.Stderr, "Relay Error: %s\n", err)
+		return ExitCodeError
+	}
+	return ExitCodeOK
+}
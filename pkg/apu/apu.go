@@ -1,26 +1,15 @@
 package apu
 
-// サウンドはgoboyのコードをベースに自分のエミュレータに合うように改造(というかほぼコピペ。。。)
-
 import (
 	"fmt"
-	"gbc/pkg/util"
 	"log"
-	"math"
-	"time"
-
-	"github.com/hajimehoshi/oto"
 )
 
 const (
-	sampleRate = 44100
-	twoPi      = 2 * math.Pi
-	perSample  = 1 / float64(sampleRate)
-
-	cpuTicksPerSample = float64(4194304) / sampleRate
-	streamLen         = 2940 // 2 * 2 * sampleRate * (1/60)
-	volume            = 0.07
-	bufferSeconds     = 60
+	defaultSampleRate = 44100
+
+	blipFlush = 256  // output samples to move to stream at once
+	maxStep   = 4096 // cycles to run at once so that blip buffer doesn't overflow
 )
 
 // APU is the GameBoy's audio processing unit. Audio comprises four
@@ -30,164 +19,338 @@ const (
 // waveform channel which can be set in RAM, and channel 4 outputs noise.
 type APU struct {
 	playing bool
+	cgb     bool
+	power   bool // NR52 bit7
+
+	memory [52]byte
+
+	chn1, chn2 square
+	chn3       wave
+	chn4       noise
+	frameStep  byte // next step of frame sequencer (0-7)
+	settings   Settings
+	lVol, rVol byte // NR50
+
+	cycles    uint64    // T-cycles APU has run
+	outputs   []*output // mix and stems
+	rate      float64   // output samples per cycle
+	samples   []int16
+	midi      *midiRecorder
+	scope     *scope
+	triggered byte // channels triggered by the current write
+}
 
-	memory      [52]byte
-	waveformRAM []byte
-
-	player                 *oto.Player
-	chn1, chn2, chn3, chn4 *Channel
-	tickCounter            float64
-	lVol, rVol             float64
-
-	audioBuffer chan [2]byte
+// Settings - audio output settings
+type Settings struct {
+	Volume     float64 // master volume (0-1)
+	SampleRate int
+	HighPass   bool    // emulate capacitors on output
+	LowPass    float64 // cutoff frequency(Hz) of speaker filter, 0 disables
 }
 
 // Init the sound emulation for a Gameboy.
-func (a *APU) Init(sound bool) {
-	a.playing = sound
-	a.waveformRAM = make([]byte, 0x20)
-	a.audioBuffer = make(chan [2]byte, streamLen)
+// Samples aren't generated until any sink is added.
+func (a *APU) Init(cgb bool, s Settings) {
+	a.cgb = cgb
+	if s.SampleRate <= 0 {
+		s.SampleRate = defaultSampleRate
+	}
+	a.settings = s
 
 	// Sets waveform ram to:
 	// 00 FF 00 FF  00 FF 00 FF  00 FF 00 FF  00 FF 00 FF
-	for x := 0x0; x < 0x20; x++ {
-		if x&2 == 0 {
-			a.waveformRAM[x] = 0x00
-		} else {
-			a.waveformRAM[x] = 0xFF
+	for x := range a.chn3.ram {
+		if x%2 == 1 {
+			a.chn3.ram[x] = 0xff
 		}
 	}
 
-	// Create the channels with their sounds
-	a.chn1 = NewChannel()
-	a.chn2 = NewChannel()
-	a.chn3 = NewChannel()
-	a.chn4 = NewChannel()
+	a.chn1.length.max, a.chn2.length.max, a.chn4.length.max = 64, 64, 64
+	a.chn3.length.max = 256
+	a.chn4.lfsr = 0x7fff
+
+	// register values after boot ROM
+	a.power = true
+	for i, value := range []byte{
+		/* 0xFF10 */ 0x80, 0xbf, 0xf3, 0xff, 0x3f,
+		/* 0xFF15 */ 0xff, 0x3f, 0x00, 0xff, 0x3f,
+		/* 0xFF1A */ 0x7f, 0xff, 0x9f, 0xff, 0x3f,
+		/* 0xFF1F */ 0xff, 0xff, 0x00, 0x00, 0x3f,
+		/* 0xFF24 */ 0x77, 0xf3,
+	} {
+		a.Write(0xFF10+uint16(i), value)
+	}
+	a.chn1.enabled = true // boot sound has already faded out
+}
 
-	if sound {
-		context, err := oto.NewContext(sampleRate, 2, 1, sampleRate/bufferSeconds)
-		if err != nil {
-			log.Fatalf("Failed to start audio: %v", err)
+// AddSink adds destination of samples
+func (a *APU) AddSink(sink AudioSink) {
+	for _, o := range a.outputs {
+		if o.mask == mixMask {
+			o.sink = multiSink{o.sink, sink}
+			return
 		}
-		a.player = context.NewPlayer()
-		a.playSound(bufferSeconds)
 	}
+	a.addOutput(mixMask, sink)
 }
 
-// Starts a goroutine which plays the sound
-func (a *APU) playSound(bufferSeconds int) {
-	frameTime := time.Second / time.Duration(bufferSeconds)
-	ticker := time.NewTicker(frameTime)
-	targetSamples := sampleRate / bufferSeconds
-	go func() {
-		var reading [2]byte
-		var buffer []byte
-		for range ticker.C {
-			fbLen := len(a.audioBuffer)
-			if fbLen >= targetSamples/2 {
-				newBuffer := make([]byte, fbLen*2)
-				for i := 0; i < fbLen*2; i += 2 {
-					reading = <-a.audioBuffer
-					newBuffer[i], newBuffer[i+1] = reading[0], reading[1]
-				}
-				buffer = newBuffer
-			}
+// AddStem adds destination of channel ${ch}(1-4) only samples
+func (a *APU) AddStem(ch int, sink AudioSink) {
+	a.addOutput(1<<(ch-1), sink)
+}
+
+func (a *APU) addOutput(mask byte, sink AudioSink) {
+	o := &output{mask: mask, sink: sink, filter: newFilter(a.settings, a.cgb)}
+	if len(a.outputs) > 0 { // keep outputs in sync
+		o.blip.time = a.outputs[0].blip.time
+	}
+	a.outputs = append(a.outputs, o)
+	a.playing = true
+	a.rate = a.outputRate()
+	a.update()
+}
 
-			a.player.Write(buffer)
+// SampleRate returns output sample rate(Hz)
+func (a *APU) SampleRate() int {
+	return a.settings.SampleRate
+}
+
+// Close flushes remaining samples and closes sinks
+func (a *APU) Close() (err error) {
+	if a.playing {
+		a.flush()
+	}
+	for _, o := range a.outputs {
+		if e := o.sink.Close(); e != nil && err == nil {
+			err = e
 		}
-	}()
+	}
+	if a.midi != nil {
+		if e := a.midi.close(a.cycles); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
-func (a *APU) Buffer(cpuTicks int, speed int) {
-	if !a.playing {
+// Step runs APU for ${cycles} T-cycles (4MHz)
+func (a *APU) Step(cycles int) {
+	a.cycles += uint64(cycles)
+	if !a.playing && a.scope == nil {
+		a.step(cycles)
 		return
 	}
-	a.tickCounter += float64(cpuTicks) / float64(speed)
-	if a.tickCounter < cpuTicksPerSample {
-		return
+
+	// run until each channel output changes and record it into blip buffer
+	for cycles > 0 {
+		n := cycles
+		if a.playing {
+			n = a.nextClock(n)
+		}
+		if a.scope != nil && a.scope.tick < n {
+			n = a.scope.tick
+		}
+		a.step(n)
+		cycles -= n
+
+		if a.scope != nil {
+			a.scope.advance(a, n)
+		}
+		if !a.playing {
+			continue
+		}
+		for _, o := range a.outputs {
+			o.blip.advance(float64(n) * a.rate)
+		}
+		a.update()
+		if a.outputs[0].blip.avail() >= blipFlush {
+			a.flush()
+		}
+	}
+}
+
+func (a *APU) step(cycles int) {
+	if a.chn1.enabled {
+		a.chn1.step(cycles)
+	}
+	if a.chn2.enabled {
+		a.chn2.step(cycles)
+	}
+	if a.chn3.enabled {
+		a.chn3.step(cycles)
 	}
-	a.tickCounter -= cpuTicksPerSample
+	if a.chn4.enabled {
+		a.chn4.step(cycles)
+	}
+}
 
-	chn1l, chn1r := a.chn1.Sample()
-	chn2l, chn2r := a.chn2.Sample()
-	chn3l, chn3r := a.chn3.Sample()
-	chn4l, chn4r := a.chn4.Sample()
+// nextClock returns cycles until any channel is clocked next (${cycles} at most)
+func (a *APU) nextClock(cycles int) int {
+	n := cycles
+	if n > maxStep {
+		n = maxStep
+	}
+	for _, t := range [4]struct {
+		on    bool
+		timer int
+	}{
+		{a.chn1.enabled, a.chn1.timer},
+		{a.chn2.enabled, a.chn2.timer},
+		{a.chn3.enabled, a.chn3.timer},
+		{a.chn4.enabled && a.chn4.shift < 14, a.chn4.timer},
+	} {
+		if t.on && t.timer > 0 && t.timer < n {
+			n = t.timer
+		}
+	}
+	return n
+}
 
-	valL := (chn1l + chn2l + chn3l + chn4l) / 4
-	valR := (chn1r + chn2r + chn3r + chn4r) / 4
+// FrameSequencer is clocked at 512Hz by DIV
+func (a *APU) FrameSequencer() {
+	step := a.frameStep
+	if step%2 == 0 { // 256Hz
+		a.chn1.clockLength()
+		a.chn2.clockLength()
+		a.chn3.clockLength()
+		a.chn4.clockLength()
+	}
+	if step == 2 || step == 6 { // 128Hz
+		a.chn1.clockSweep()
+	}
+	if step == 7 { // 64Hz
+		a.chn1.envelope.clock()
+		a.chn2.envelope.clock()
+		a.chn4.envelope.clock()
+	}
+	a.frameStep = (step + 1) % 8
+	a.update()
+	a.events()
+}
 
-	lVol, rVol := valL*a.lVol*volume, valR*a.rVol*volume
-	a.audioBuffer <- [2]byte{byte(lVol), byte(rVol)}
+// dac converts digital value(0-15) into analog value(-1~1)
+func dac(ch *channel, digital byte) float64 {
+	if !ch.dac || ch.debugOff {
+		return 0
+	}
+	return float64(digital)/7.5 - 1
 }
 
-var soundMask = []byte{
-	/* 0xFF10 */ 0xFF, 0xC0, 0xFF, 0x00, 0x40,
-	/* 0xFF15 */ 0x00, 0xC0, 0xFF, 0x00, 0x40,
-	/* 0xFF1A */ 0x80, 0x00, 0x60, 0x00, 0x40,
-	/* 0xFF20 */ 0x00, 0x3F, 0xFF, 0xFF, 0x40,
-	/* 0xFF24 */ 0xFF, 0xFF, 0x80,
+// update records the current output levels into blip buffers
+func (a *APU) update() {
+	if !a.playing {
+		return
+	}
+	levels := a.levels()
+	for _, o := range a.outputs {
+		var l, r float64
+		for i, lr := range levels {
+			if o.mask&(1<<i) != 0 {
+				l, r = l+lr[0], r+lr[1]
+			}
+		}
+		o.blip.set(l, r)
+	}
 }
 
-var channel3Volume = map[byte]float64{0: 0, 1: 1, 2: 0.5, 3: 0.25}
+// levels returns output level of each channel after panning and master volume
+func (a *APU) levels() (levels [4][2]float64) {
+	outputs := [4]float64{
+		dac(&a.chn1.channel, a.chn1.output()),
+		dac(&a.chn2.channel, a.chn2.output()),
+		dac(&a.chn3.channel, a.chn3.output()),
+		dac(&a.chn4.channel, a.chn4.output()),
+	}
+	channels := [4]*channel{&a.chn1.channel, &a.chn2.channel, &a.chn3.channel, &a.chn4.channel}
 
-var squareLimits = map[byte]float64{
-	0: -0.25, // 12.5% ( _-------_-------_------- )
-	1: -0.5,  // 25%   ( __------__------__------ )
-	2: 0,     // 50%   ( ____----____----____---- ) (normal)
-	3: 0.5,   // 75%   ( ______--______--______-- )
+	volL := float64(a.lVol+1) / 8 / 4 * a.settings.Volume
+	volR := float64(a.rVol+1) / 8 / 4 * a.settings.Volume
+	for i, ch := range channels {
+		if ch.left {
+			levels[i][0] = outputs[i] * volL
+		}
+		if ch.right {
+			levels[i][1] = outputs[i] * volR
+		}
+	}
+	return levels
+}
+
+// unreadable bits are read as 1
+var readMask = [0x20]byte{
+	/* 0xFF10 */ 0x80, 0x3F, 0x00, 0xFF, 0xBF,
+	/* 0xFF15 */ 0xFF, 0x3F, 0x00, 0xFF, 0xBF,
+	/* 0xFF1A */ 0x7F, 0xFF, 0x9F, 0xFF, 0xBF,
+	/* 0xFF1F */ 0xFF, 0xFF, 0x00, 0x00, 0xBF,
+	/* 0xFF24 */ 0x00, 0x00, 0x70,
+	/* 0xFF27 */ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
 }
 
 // Read returns a value from the APU.
 func (a *APU) Read(address uint16) byte {
 	if address >= 0xFF30 {
-		return a.waveformRAM[address-0xFF30]
+		return a.readWaveform(address)
+	}
+	if address == 0xFF26 {
+		value := readMask[0x16] | a.status()
+		if a.power {
+			value |= 0x80
+		}
+		return value
+	}
+	return a.memory[address-0xFF00] | readMask[address-0xFF10]
+}
+
+// NR52 bit0-3
+func (a *APU) status() (value byte) {
+	for i, on := range [4]bool{a.chn1.enabled, a.chn2.enabled, a.chn3.enabled, a.chn4.enabled} {
+		if on {
+			value |= 1 << i
+		}
 	}
-	// TODO: we should modify the sound memory as we're sampling
-	return a.memory[address-0xFF00] & soundMask[address-0xFF10]
+	return value
 }
 
 // Write a value to the APU registers.
 func (a *APU) Write(address uint16, value byte) {
+	defer a.events()
+	defer a.update()
+	if address == 0xFF26 {
+		a.writeNR52(value)
+		return
+	}
+	if address > 0xFF26 { // unused
+		return
+	}
+	if !a.power { // registers are read-only while power off. DMG can still write length counters
+		if !a.cgb {
+			a.writeLength(address, value)
+		}
+		return
+	}
 	a.memory[address-0xFF00] = value
 
 	switch address {
 	// Channel 1
 	case 0xFF10:
-		// -PPP NSSS Sweep period, negate, shift
-		a.chn1.sweepStepLen = (a.memory[0x10] & 0b111_0000) >> 4
-		a.chn1.sweepSteps = a.memory[0x10] & 0b111
-		a.chn1.sweepIncrease = !util.Bit(a.memory[0x10], 3) // 1 = decrease
+		a.chn1.writeSweep(value)
 	case 0xFF11:
 		// DDLL LLLL Duty, Length load
-		duty := (value & 0b1100_0000) >> 6
-		a.chn1.generator = Square(squareLimits[duty])
-		a.chn1.length = int(value & 0b0011_1111)
+		a.chn1.duty = value >> 6
+		a.chn1.length.load(value)
 	case 0xFF12:
-		// VVVV APPP - Starting volume, Envelop add mode, period
-		envVolume, envDirection, envSweep := a.extractEnvelope(value)
-		a.chn1.envelopeVolume = int(envVolume)
-		a.chn1.envelopeSamples = int(envSweep) * sampleRate / 64
-		a.chn1.envelopeIncreasing = envDirection == 1
+		a.chn1.envelope.write(value)
+		a.chn1.setDAC(value&0xf8 != 0)
 	case 0xFF13:
 		// FFFF FFFF Frequency LSB
-		frequencyValue := uint16(a.memory[0x14]&0b111)<<8 | uint16(value)
-		a.chn1.frequency = 131072 / (2048 - float64(frequencyValue))
+		a.chn1.freq = a.chn1.freq&0x700 | uint16(value)
 	case 0xFF14:
-		// TL-- -FFF Trigger, Length Enable, Frequencu MSB
-		frequencyValue := uint16(value&0b111)<<8 | uint16(a.memory[0x13])
-		a.chn1.frequency = 131072 / (2048 - float64(frequencyValue))
-		if util.Bit(value, 7) {
-			if a.chn1.length == 0 {
-				a.chn1.length = 64
-			}
-			duration := -1
-			if util.Bit(value, 6) { // 1 = use length
-				duration = int(float64(a.chn1.length)*(1/64)) * sampleRate
-			}
-			a.chn1.Reset(duration)
-			a.chn1.envelopeSteps = a.chn1.envelopeVolume
-			a.chn1.envelopeStepsInit = a.chn1.envelopeVolume
-			// TODO: Square 1's sweep does several things (see frequency sweep).
+		// TL-- -FFF Trigger, Length Enable, Frequency MSB
+		a.chn1.freq = a.chn1.freq&0xff | uint16(value&0b111)<<8
+		if a.writeNRx4(&a.chn1.channel, value) {
+			a.chn1.trigger()
+			a.chn1.triggerSweep()
+			a.triggered |= 1 << 0
 		}
 
 	// Channel 2
@@ -195,125 +358,169 @@ func (a *APU) Write(address uint16, value byte) {
 		// ---- ---- Not used
 	case 0xFF16:
 		// DDLL LLLL Duty, Length load (64-L)
-		pattern := (value & 0b1100_0000) >> 6
-		a.chn2.generator = Square(squareLimits[pattern])
-		a.chn2.length = int(value & 0b11_1111)
+		a.chn2.duty = value >> 6
+		a.chn2.length.load(value)
 	case 0xFF17:
-		// VVVV APPP Starting volume, Envelope add mode, period
-		envVolume, envDirection, envSweep := a.extractEnvelope(value)
-		a.chn2.envelopeVolume = int(envVolume)
-		a.chn2.envelopeSamples = int(envSweep) * sampleRate / 64
-		a.chn2.envelopeIncreasing = envDirection == 1
+		a.chn2.envelope.write(value)
+		a.chn2.setDAC(value&0xf8 != 0)
 	case 0xFF18:
 		// FFFF FFFF Frequency LSB
-		frequencyValue := uint16(a.memory[0x19]&0b111)<<8 | uint16(value)
-		a.chn2.frequency = 131072 / (2048 - float64(frequencyValue))
+		a.chn2.freq = a.chn2.freq&0x700 | uint16(value)
 	case 0xFF19:
 		// TL-- -FFF Trigger, Length enable, Frequency MSB
-		if util.Bit(value, 7) {
-			if a.chn2.length == 0 {
-				a.chn2.length = 64
-			}
-			duration := -1
-			if util.Bit(value, 6) {
-				duration = int(float64(a.chn2.length)*(1/64)) * sampleRate
-			}
-			a.chn2.Reset(duration)
-			a.chn2.envelopeSteps = a.chn2.envelopeVolume
-			a.chn2.envelopeStepsInit = a.chn2.envelopeVolume
+		a.chn2.freq = a.chn2.freq&0xff | uint16(value&0b111)<<8
+		if a.writeNRx4(&a.chn2.channel, value) {
+			a.chn2.trigger()
+			a.triggered |= 1 << 1
 		}
-		frequencyValue := uint16(value&0b111)<<8 | uint16(a.memory[0x18])
-		a.chn2.frequency = 131072 / (2048 - float64(frequencyValue))
 
 	// Channel 3
 	case 0xFF1A:
 		// E--- ---- DAC power
-		a.chn3.envelopeStepsInit = int((value & 0b1000_0000) >> 7)
+		a.chn3.setDAC(value&0x80 != 0)
 	case 0xFF1B:
 		// LLLL LLLL Length load
-		a.chn3.length = int(value)
+		a.chn3.length.load(value)
 	case 0xFF1C:
 		// -VV- ---- Volume code
-		selection := (value & 0b110_0000) >> 5
-		a.chn3.amplitude = channel3Volume[selection]
+		a.chn3.volumeCode = (value >> 5) & 0b11
 	case 0xFF1D:
 		// FFFF FFFF Frequency LSB
-		frequencyValue := uint16(a.memory[0x1E]&0b111)<<8 | uint16(value)
-		a.chn3.frequency = 65536 / (2048 - float64(frequencyValue))
+		a.chn3.freq = a.chn3.freq&0x700 | uint16(value)
 	case 0xFF1E:
 		// TL-- -FFF Trigger, Length enable, Frequency MSB
-		if util.Bit(value, 7) {
-			if a.chn3.length == 0 {
-				a.chn3.length = 256
-			}
-			duration := -1
-			if value&0b100_0000 != 0 { // 1 = use length
-				duration = int((256-float64(a.chn3.length))*(1/256)) * sampleRate
-			}
-			a.chn3.generator = Waveform(func(i int) byte { return a.waveformRAM[i] })
-			a.chn3.duration = duration
+		a.chn3.freq = a.chn3.freq&0xff | uint16(value&0b111)<<8
+		if a.writeNRx4(&a.chn3.channel, value) {
+			a.chn3.trigger()
+			a.triggered |= 1 << 2
 		}
-		frequencyValue := uint16(value&0b111)<<8 | uint16(a.memory[0x1D])
-		a.chn3.frequency = 65536 / (2048 - float64(frequencyValue))
 
 	// Channel 4
 	case 0xFF1F:
 		// ---- ---- Not used
 	case 0xFF20:
 		// --LL LLLL Length load
-		a.chn4.length = int(value & 0b11_1111)
+		a.chn4.length.load(value)
 	case 0xFF21:
-		// VVVV APPP Starting volume, Envelope add mode, period
-		envVolume, envDirection, envSweep := a.extractEnvelope(value)
-		a.chn4.envelopeVolume = int(envVolume)
-		a.chn4.envelopeSamples = int(envSweep) * sampleRate / 64
-		a.chn4.envelopeIncreasing = envDirection == 1
+		a.chn4.envelope.write(value)
+		a.chn4.setDAC(value&0xf8 != 0)
 	case 0xFF22:
 		// SSSS WDDD Clock shift, Width mode of LFSR, Divisor code
-		shiftClock := float64((value & 0b1111_0000) >> 4)
-		// TODO: counter step width
-		divRatio := float64(value & 0b111)
-		if divRatio == 0 {
-			divRatio = 0.5
-		}
-		a.chn4.frequency = 524288 / divRatio / math.Pow(2, shiftClock+1)
+		a.chn4.shift = value >> 4
+		a.chn4.width7 = value&0x08 != 0
+		a.chn4.divisor = value & 0b111
 	case 0xFF23:
 		// TL-- ---- Trigger, Length enable
-		if util.Bit(value, 7) {
-			duration := -1
-			if util.Bit(value, 6) { // 1 = use length
-				duration = int(float64(61-a.chn4.length)*(1/256)) * sampleRate
-			}
-			a.chn4.generator = Noise()
-			a.chn4.Reset(duration)
-			a.chn4.envelopeSteps = a.chn4.envelopeVolume
-			a.chn4.envelopeStepsInit = a.chn4.envelopeVolume
+		if a.writeNRx4(&a.chn4.channel, value) {
+			a.chn4.trigger()
+			a.triggered |= 1 << 3
 		}
 
 	case 0xFF24:
 		// Volume control
-		a.lVol = float64((a.memory[0x24]&0x70)>>4) / 7
-		a.rVol = float64(a.memory[0x24]&0x7) / 7
+		a.lVol = (value >> 4) & 0b111
+		a.rVol = value & 0b111
 
 	case 0xFF25:
 		// Channel control
-		a.chn1.onR = value&0x1 != 0
-		a.chn2.onR = value&0x2 != 0
-		a.chn3.onR = value&0x4 != 0
-		a.chn4.onR = value&0x8 != 0
-		a.chn1.onL = value&0x10 != 0
-		a.chn2.onL = value&0x20 != 0
-		a.chn3.onL = value&0x40 != 0
-		a.chn4.onL = value&0x80 != 0
-	}
-	// TODO: if writing to FF26 bit 7 destroy all contents (also cannot access)
+		a.chn1.right = value&0x1 != 0
+		a.chn2.right = value&0x2 != 0
+		a.chn3.right = value&0x4 != 0
+		a.chn4.right = value&0x8 != 0
+		a.chn1.left = value&0x10 != 0
+		a.chn2.left = value&0x20 != 0
+		a.chn3.left = value&0x40 != 0
+		a.chn4.left = value&0x80 != 0
+	}
+}
+
+func (a *APU) writeLength(address uint16, value byte) {
+	switch address {
+	case 0xFF11:
+		a.chn1.length.load(value)
+	case 0xFF16:
+		a.chn2.length.load(value)
+	case 0xFF1B:
+		a.chn3.length.load(value)
+	case 0xFF20:
+		a.chn4.length.load(value)
+	}
+}
+
+// NR52: P--- ---- Power
+func (a *APU) writeNR52(value byte) {
+	on := value&0x80 != 0
+	switch {
+	case a.power && !on: // power off clears NR10-NR51
+		lengths := [4]int{a.chn1.length.counter, a.chn2.length.counter, a.chn3.length.counter, a.chn4.length.counter}
+		for addr := uint16(0xFF10); addr < 0xFF26; addr++ {
+			a.Write(addr, 0)
+		}
+		if !a.cgb { // length counters are unaffected on DMG
+			a.chn1.length.counter, a.chn2.length.counter = lengths[0], lengths[1]
+			a.chn3.length.counter, a.chn4.length.counter = lengths[2], lengths[3]
+		}
+	case !a.power && on:
+		a.frameStep = 0
+		a.chn1.pos, a.chn2.pos = 0, 0
+		a.chn3.sample = 0
+	}
+	a.power = on
+}
+
+// writeNRx4 handles length enable and returns true if the channel is triggered
+func (a *APU) writeNRx4(ch *channel, value byte) bool {
+	trigger := value&0x80 != 0
+
+	// if the next frame sequencer step doesn't clock length, enabling length clocks it once
+	extra := a.frameStep%2 == 1
+	wasEnabled := ch.length.enabled
+	ch.length.enabled = value&0x40 != 0
+	if extra && !wasEnabled && ch.length.enabled && ch.length.counter > 0 {
+		ch.length.counter--
+		if ch.length.counter == 0 && !trigger {
+			ch.enabled = false
+		}
+	}
+
+	if trigger {
+		ch.enabled = ch.dac
+		if ch.length.counter == 0 {
+			ch.length.counter = ch.length.max
+			if extra && ch.length.enabled {
+				ch.length.counter--
+			}
+		}
+	}
+	return trigger
+}
+
+// While channel 3 is playing, wave RAM access goes to the byte channel 3 is reading.
+// On DMG, it succeeds only just when channel 3 reads the byte.
+func (a *APU) waveformIndex(address uint16) (int, bool) {
+	w := &a.chn3
+	if !w.enabled {
+		return int(address - 0xFF30), true
+	}
+	if !a.cgb && w.sinceRead >= 2 {
+		return 0, false
+	}
+	return int(w.pos / 2), true
+}
+
+func (a *APU) readWaveform(address uint16) byte {
+	i, ok := a.waveformIndex(address)
+	if !ok {
+		return 0xff
+	}
+	return a.chn3.ram[i]
 }
 
 // WriteWaveform writes a value to the waveform ram.
 func (a *APU) WriteWaveform(address uint16, value byte) {
-	soundIndex := (address - 0xFF30) * 2
-	a.waveformRAM[soundIndex] = (value >> 4) & 0xF * 0x11
-	a.waveformRAM[soundIndex+1] = value & 0xF * 0x11
+	if i, ok := a.waveformIndex(address); ok {
+		a.chn3.ram[i] = value
+	}
 }
 
 // ToggleSoundChannel toggles a sound channel for debugging.
@@ -328,6 +535,7 @@ func (a *APU) ToggleSoundChannel(channel int) {
 	case 4:
 		a.chn4.debugOff = !a.chn4.debugOff
 	}
+	a.update()
 	log.Printf("Toggle Channel %v mute", channel)
 }
 
@@ -339,11 +547,3 @@ func (a *APU) LogSoundState() {
 	fmt.Printf("  0xFF1D FFFF FFFF = %08b\n", a.memory[0x1D])
 	fmt.Printf("  0xFF1E TL-- -FFF = %08b\n", a.memory[0x1E])
 }
-
-// Extract some envelope variables from a byte.
-func (a *APU) extractEnvelope(val byte) (volume, direction, sweep byte) {
-	volume = (val & 0xF0) >> 4
-	direction = (val & 0x8) >> 3 // 1 or 0
-	sweep = val & 0x7
-	return
-}
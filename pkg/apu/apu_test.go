@@ -0,0 +1,218 @@
+package apu
+
+import "testing"
+
+// Register level checks of blargg's dmg_sound tests. The test ROMs aren't bundled, so each test names the ROM it follows.
+
+// newAPU returns APU which is powered off and on again like the test ROMs do at start. Frame sequencer is at step 0.
+func newAPU(cgb bool) *APU {
+	a := &APU{}
+	a.Init(cgb, Settings{})
+	a.Write(0xFF26, 0x00)
+	a.Write(0xFF26, 0x80)
+	return a
+}
+
+// lengthClocks clocks frame sequencer until channel ${ch}(0-3) is disabled and returns the number of length clocks
+func lengthClocks(a *APU, ch int) int {
+	clocks := 0
+	for i := 0; i < 8*512 && a.Read(0xFF26)&(1<<ch) != 0; i++ {
+		if a.frameStep%2 == 0 {
+			clocks++
+		}
+		a.FrameSequencer()
+	}
+	return clocks
+}
+
+// 01-registers: unused bits and write-only registers are read as 1
+func TestRegisters(t *testing.T) {
+	masks := []byte{
+		/* NR10 */ 0x80, 0x3F, 0x00, 0xFF, 0xBF,
+		/* NR20 */ 0xFF, 0x3F, 0x00, 0xFF, 0xBF,
+		/* NR30 */ 0x7F, 0xFF, 0x9F, 0xFF, 0xBF,
+		/* NR40 */ 0xFF, 0xFF, 0x00, 0x00, 0xBF,
+		/* NR50 */ 0x00, 0x00,
+	}
+	for _, value := range []byte{0x00, 0xFF} {
+		for i, mask := range masks {
+			a := newAPU(false)
+			addr := 0xFF10 + uint16(i)
+			a.Write(addr, value)
+			if got := a.Read(addr); got != value|mask {
+				t.Errorf("%04x: wrote %02x, read %02x, want %02x", addr, value, got, value|mask)
+			}
+		}
+	}
+
+	a := newAPU(false)
+	for addr := uint16(0xFF27); addr < 0xFF30; addr++ {
+		a.Write(addr, 0x00)
+		if got := a.Read(addr); got != 0xFF {
+			t.Errorf("unused %04x is read as %02x", addr, got)
+		}
+	}
+	if got := a.Read(0xFF26); got != 0xF0 {
+		t.Errorf("NR52 is %02x while power on without channels, want f0", got)
+	}
+	a.Write(0xFF26, 0x00)
+	if got := a.Read(0xFF26); got != 0x70 {
+		t.Errorf("NR52 is %02x while power off, want 70", got)
+	}
+}
+
+// 02-len ctr: length counter disables channel after 64 - NRx1 (256 - NR31) clocks at 256Hz
+func TestLengthCounter(t *testing.T) {
+	tests := []struct {
+		name    string
+		ch      int
+		dac     uint16 // register which turns DAC on
+		length  uint16
+		nrx4    uint16
+		load    byte
+		want    int
+		enabled bool // length isn't enabled by trigger
+	}{
+		{"square 1", 0, 0xFF12, 0xFF11, 0xFF14, 0x3C, 4, true},
+		{"square 2", 1, 0xFF17, 0xFF16, 0xFF19, 0x00, 64, true},
+		{"wave", 2, 0xFF1A, 0xFF1B, 0xFF1E, 0xF0, 16, true},
+		{"wave full", 2, 0xFF1A, 0xFF1B, 0xFF1E, 0x00, 256, true},
+		{"noise", 3, 0xFF21, 0xFF20, 0xFF23, 0x3F, 1, true},
+		{"length disabled", 0, 0xFF12, 0xFF11, 0xFF14, 0x3C, 0, false},
+	}
+	for _, tt := range tests {
+		a := newAPU(false)
+		a.Write(tt.dac, 0xF0)
+		a.Write(tt.length, tt.load)
+		nrx4 := byte(0x80)
+		if tt.enabled {
+			nrx4 |= 0x40
+		}
+		a.Write(tt.nrx4, nrx4)
+		if a.Read(0xFF26)&(1<<tt.ch) == 0 {
+			t.Errorf("%s: channel isn't enabled by trigger", tt.name)
+			continue
+		}
+		clocks := lengthClocks(a, tt.ch)
+		if !tt.enabled {
+			if a.Read(0xFF26)&(1<<tt.ch) == 0 {
+				t.Errorf("%s: channel is disabled after %d clocks", tt.name, clocks)
+			}
+			continue
+		}
+		if clocks != tt.want {
+			t.Errorf("%s: channel is disabled after %d clocks, want %d", tt.name, clocks, tt.want)
+		}
+	}
+}
+
+// 03-trigger: enabling length in the first half of length period clocks it once more
+func TestLengthExtraClock(t *testing.T) {
+	a := newAPU(false)
+	a.FrameSequencer() // the next step doesn't clock length
+	a.Write(0xFF12, 0xF0)
+	a.Write(0xFF11, 0x3F) // 1 clock left
+	a.Write(0xFF14, 0x40) // enabling length clocks it to 0
+	if a.chn1.length.counter != 0 {
+		t.Fatalf("length is %d after extra clock, want 0", a.chn1.length.counter)
+	}
+
+	// trigger with length 0 loads 64, and the extra clock makes it 63
+	a.Write(0xFF14, 0xC0)
+	if a.chn1.length.counter != 63 {
+		t.Errorf("length is %d after trigger, want 63", a.chn1.length.counter)
+	}
+
+	// enabling length while it's already enabled doesn't clock it
+	b := newAPU(false)
+	b.FrameSequencer()
+	b.Write(0xFF12, 0xF0)
+	b.Write(0xFF11, 0x3C)
+	b.Write(0xFF14, 0xC0)
+	b.Write(0xFF14, 0x40)
+	if b.chn1.length.counter != 3 {
+		t.Errorf("length is %d, want 3 (clocked only once)", b.chn1.length.counter)
+	}
+}
+
+// 04-sweep, 05-sweep details, 06-overflow on trigger
+func TestSweep(t *testing.T) {
+	tests := []struct {
+		name    string
+		nr10    byte
+		freq    uint16
+		clocks  int // sweep clocks (frame sequencer step 2 and 6)
+		enabled bool
+	}{
+		{"overflow on trigger", 0x01, 0x7FF, 0, false},
+		{"no overflow on trigger", 0x01, 0x400, 0, true},
+		{"shift 0 doesn't check on trigger", 0x00, 0x7FF, 0, true},
+		{"overflow after update", 0x11, 0x400, 1, false},
+		{"period 0 doesn't update", 0x01, 0x500, 4, true},
+		{"subtraction never overflows", 0x19, 0x7FF, 4, true},
+	}
+	for _, tt := range tests {
+		a := newAPU(false)
+		a.Write(0xFF12, 0xF0)
+		a.Write(0xFF10, tt.nr10)
+		a.Write(0xFF13, byte(tt.freq))
+		a.Write(0xFF14, 0x80|byte(tt.freq>>8))
+		for clocks := 0; clocks < tt.clocks; {
+			if a.frameStep == 2 || a.frameStep == 6 {
+				clocks++
+			}
+			a.FrameSequencer()
+		}
+		if enabled := a.Read(0xFF26)&0x01 != 0; enabled != tt.enabled {
+			t.Errorf("%s: channel 1 is enabled: %v, want %v", tt.name, enabled, tt.enabled)
+		}
+	}
+
+	// clearing negate after subtraction is calculated disables channel
+	a := newAPU(false)
+	a.Write(0xFF12, 0xF0)
+	a.Write(0xFF10, 0x19)
+	a.Write(0xFF14, 0x84)
+	a.Write(0xFF10, 0x11)
+	if a.Read(0xFF26)&0x01 != 0 {
+		t.Error("channel 1 is still enabled after leaving negate mode")
+	}
+}
+
+// 08-len ctr during power, 11-regs after power
+func TestPowerOff(t *testing.T) {
+	for _, cgb := range []bool{false, true} {
+		a := newAPU(cgb)
+		a.Write(0xFF11, 0x3C) // 4 clocks
+		a.Write(0xFF26, 0x00)
+		if got := a.Read(0xFF11); got != 0x3F {
+			t.Errorf("cgb %v: NR11 is %02x after power off, want 3f", cgb, got)
+		}
+		a.Write(0xFF12, 0xF0) // ignored while power off
+		if got := a.Read(0xFF12); got != 0x00 {
+			t.Errorf("cgb %v: NR12 is written while power off", cgb)
+		}
+		a.Write(0xFF26, 0x80)
+
+		a.Write(0xFF12, 0xF0)
+		a.Write(0xFF14, 0xC0)
+		want := 4 // DMG keeps length counters
+		if cgb {
+			want = 64
+		}
+		if clocks := lengthClocks(a, 0); clocks != want {
+			t.Errorf("cgb %v: channel is disabled after %d clocks, want %d", cgb, clocks, want)
+		}
+	}
+
+	// DMG can write length counters while power off
+	a := newAPU(false)
+	a.Write(0xFF26, 0x00)
+	a.Write(0xFF20, 0x3E) // 2 clocks
+	a.Write(0xFF26, 0x80)
+	a.Write(0xFF21, 0xF0)
+	a.Write(0xFF23, 0xC0)
+	if clocks := lengthClocks(a, 3); clocks != 2 {
+		t.Errorf("length written while power off: disabled after %d clocks, want 2", clocks)
+	}
+}
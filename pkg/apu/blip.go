@@ -0,0 +1,110 @@
+package apu
+
+import "math"
+
+// blip - band-limited synthesis buffer
+// Amplitude changes are added as band-limited steps at sub-sample precision, then integrated into PCM samples.
+const (
+	blipTaps   = 16   // width of band-limited impulse
+	blipPhases = 64   // sub-sample resolution
+	blipSize   = 1024 // capacity in output samples
+	blipCutoff = 0.9  // relative to Nyquist frequency
+)
+
+var blipKernel [blipPhases][blipTaps]float64
+
+func init() {
+	for p := range blipKernel {
+		frac := float64(p) / blipPhases
+		sum := 0.
+		for k := range blipKernel[p] {
+			x := float64(k) - (blipTaps/2 - 1) - frac
+			h := sinc(x*blipCutoff) * blackman(x)
+			blipKernel[p][k] = h
+			sum += h
+		}
+		for k := range blipKernel[p] { // each step reaches exactly its amplitude
+			blipKernel[p][k] /= sum
+		}
+	}
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman window over -blipTaps/2 ~ blipTaps/2
+func blackman(x float64) float64 {
+	t := 2 * math.Pi * x / blipTaps
+	return 0.42 + 0.5*math.Cos(t) + 0.08*math.Cos(2*t)
+}
+
+type blip struct {
+	time  float64 // current time in output samples from buf[0]
+	buf   [2][blipSize + blipTaps]float64
+	sum   [2]float64 // integrator
+	level [2]float64 // current amplitude
+}
+
+// advance moves current time by ${samples}
+func (b *blip) advance(samples float64) {
+	b.time += samples
+}
+
+// set changes amplitude at current time
+func (b *blip) set(l, r float64) {
+	b.addDelta(0, l-b.level[0])
+	b.addDelta(1, r-b.level[1])
+	b.level = [2]float64{l, r}
+}
+
+func (b *blip) addDelta(ch int, delta float64) {
+	if delta == 0 {
+		return
+	}
+	i := int(b.time)
+	phase := int((b.time - float64(i)) * blipPhases)
+	buf := b.buf[ch][i : i+blipTaps]
+	for k, h := range &blipKernel[phase] {
+		buf[k] += delta * h
+	}
+}
+
+// avail returns the number of samples which no further step affects
+func (b *blip) avail() int {
+	return int(b.time)
+}
+
+// read appends completed samples to ${out} as interleaved 16bit stereo
+func (b *blip) read(out []int16, f *filter) []int16 {
+	n := b.avail()
+	for i := 0; i < n; i++ {
+		for ch := range b.buf {
+			b.sum[ch] += b.buf[ch][i]
+			out = append(out, clamp16(f.apply(ch, b.sum[ch])))
+		}
+	}
+
+	for ch := range b.buf {
+		copy(b.buf[ch][:], b.buf[ch][n:])
+		tail := b.buf[ch][len(b.buf[ch])-n:]
+		for i := range tail {
+			tail[i] = 0
+		}
+	}
+	b.time -= float64(n)
+	return out
+}
+
+func clamp16(v float64) int16 {
+	switch {
+	case v > 1:
+		v = 1
+	case v < -1:
+		v = -1
+	}
+	return int16(v * 32767)
+}
@@ -1,126 +1,199 @@
 package apu
 
-import "math"
-
-// NewChannel returns a new sound channel using a sampling function.
-func NewChannel() *Channel {
-	return &Channel{}
-}
-
-// Channel represents one of four Gameboy sound channels.
-type Channel struct {
-	frequency float64
-	generator WaveGenerator
-	time      float64
-	amplitude float64
-
-	// Duration in samples
-	duration int
-	length   int
-
-	envelopeVolume     int
-	envelopeTime       int
-	envelopeSteps      int
-	envelopeStepsInit  int
-	envelopeSamples    int
-	envelopeIncreasing bool
-
-	sweepTime     float64
-	sweepStepLen  byte
-	sweepSteps    byte
-	sweepStep     byte
-	sweepIncrease bool
-
-	onL bool
-	onR bool
+// channel - state common to all 4 channels
+type channel struct {
+	enabled     bool // NR52 status bit
+	dac         bool
+	length      length
+	left, right bool
+
 	// Debug flag to turn off sound output
 	debugOff bool
 }
 
-// Sample returns a single sample for streaming the sound output. Each sample
-// will increase the internal timer based on the global sample rate.
-func (chn *Channel) Sample() (outputL, outputR float64) {
-	var output float64
-	step := chn.frequency * twoPi / float64(sampleRate)
-	chn.time += step
-	if chn.shouldPlay() {
-		// Take the sample value from the generator
-		if !chn.debugOff {
-			output = float64(chn.generator(chn.time)) * chn.amplitude
-		}
-		if chn.duration > 0 {
-			chn.duration--
+// length counter disables channel when it reaches 0
+type length struct {
+	enabled bool
+	counter int
+	max     int // 64 or 256(wave)
+}
+
+func (l *length) load(value byte) {
+	l.counter = l.max - (int(value) & (l.max - 1))
+}
+
+func (ch *channel) clockLength() {
+	if ch.length.enabled && ch.length.counter > 0 {
+		ch.length.counter--
+		if ch.length.counter == 0 {
+			ch.enabled = false
 		}
 	}
-	chn.updateEnvelope()
-	chn.updateSweep()
-	if chn.onL {
-		outputL = output
-	}
-	if chn.onR {
-		outputR = output
-	}
-	return
-}
-
-// Reset the channel to some default variables for the sweep, amplitude,
-// envelope and duration.
-func (chn *Channel) Reset(duration int) {
-	chn.amplitude = 1
-	chn.envelopeTime = 0
-	chn.sweepTime = 0
-	chn.sweepStep = 0
-	chn.duration = duration
-}
-
-// Returns if the channel should be playing or not.
-func (chn *Channel) shouldPlay() bool {
-	return (chn.duration == -1 || chn.duration > 0) &&
-		chn.generator != nil && chn.envelopeStepsInit > 0
-}
-
-// Update the state of the channels envelope.
-func (chn *Channel) updateEnvelope() {
-	if chn.envelopeSamples > 0 {
-		chn.envelopeTime += 1
-		if chn.envelopeSteps > 0 && chn.envelopeTime >= chn.envelopeSamples {
-			chn.envelopeTime -= chn.envelopeSamples
-			chn.envelopeSteps--
-			if chn.envelopeSteps == 0 {
-				chn.amplitude = 0
-			} else if chn.envelopeIncreasing {
-				chn.amplitude = 1 - float64(chn.envelopeSteps)/float64(chn.envelopeStepsInit)
-			} else {
-				chn.amplitude = float64(chn.envelopeSteps) / float64(chn.envelopeStepsInit)
-			}
-		}
+}
+
+func (ch *channel) setDAC(on bool) {
+	ch.dac = on
+	if !on {
+		ch.enabled = false
+	}
+}
+
+// envelope - volume envelope of square and noise channels
+type envelope struct {
+	initial  byte
+	increase bool
+	period   byte
+	volume   byte
+	timer    byte
+}
+
+// NRx2: VVVV APPP Starting volume, Envelope add mode, period
+func (e *envelope) write(value byte) {
+	e.initial = value >> 4
+	e.increase = value&0x08 != 0
+	e.period = value & 0x07
+}
+
+func (e *envelope) trigger() {
+	e.volume = e.initial
+	e.timer = e.period
+	if e.timer == 0 {
+		e.timer = 8
+	}
+}
+
+func (e *envelope) clock() {
+	if e.period == 0 {
+		return
+	}
+	if e.timer > 0 {
+		e.timer--
+	}
+	if e.timer > 0 {
+		return
+	}
+
+	e.timer = e.period
+	if e.increase && e.volume < 15 {
+		e.volume++
+	} else if !e.increase && e.volume > 0 {
+		e.volume--
+	}
+}
+
+var dutyTable = [4][8]byte{
+	{0, 0, 0, 0, 0, 0, 0, 1}, // 12.5% ( _______- )
+	{1, 0, 0, 0, 0, 0, 0, 1}, // 25%   ( -______- )
+	{1, 0, 0, 0, 0, 1, 1, 1}, // 50%   ( -____--- )
+	{0, 1, 1, 1, 1, 1, 1, 0}, // 75%   ( _------_ )
+}
+
+// square - channel 1 and 2
+type square struct {
+	channel
+	envelope
+	sweep sweep // channel 1 only
+
+	duty  byte
+	freq  uint16 // 11bit
+	timer int
+	pos   byte // duty step
+}
+
+// sweep - frequency sweep of channel 1
+type sweep struct {
+	period  byte
+	negate  bool
+	shift   byte
+	timer   byte
+	enabled bool
+	shadow  uint16
+	negated bool // subtraction is calculated since the last trigger
+}
+
+func (s *square) step(cycles int) {
+	s.timer -= cycles
+	if s.timer > 0 {
+		return
+	}
+	period := (2048 - int(s.freq)) * 4
+	n := (-s.timer)/period + 1
+	s.timer += n * period
+	s.pos = byte((int(s.pos) + n) & 7)
+}
+
+func (s *square) output() byte {
+	if !s.enabled {
+		return 0
+	}
+	return dutyTable[s.duty][s.pos] * s.volume
+}
+
+func (s *square) trigger() {
+	s.timer = (2048 - int(s.freq)) * 4
+	s.envelope.trigger()
+}
+
+// NR10: -PPP NSSS Sweep period, negate, shift
+func (s *square) writeSweep(value byte) {
+	s.sweep.period = (value >> 4) & 0x07
+	s.sweep.negate = value&0x08 != 0
+	s.sweep.shift = value & 0x07
+	if !s.sweep.negate && s.sweep.negated { // clearing negate mode after subtraction disables channel
+		s.enabled = false
 	}
 }
 
-var sweepTimes = map[byte]float64{
-	1: 7.8 / 1000,
-	2: 15.6 / 1000,
-	3: 23.4 / 1000,
-	4: 31.3 / 1000,
-	5: 39.1 / 1000,
-	6: 46.9 / 1000,
-	7: 54.7 / 1000,
+func (s *square) triggerSweep() {
+	sw := &s.sweep
+	sw.shadow = s.freq
+	sw.timer = sw.period
+	if sw.timer == 0 {
+		sw.timer = 8
+	}
+	sw.enabled = sw.period > 0 || sw.shift > 0
+	sw.negated = false
+	if sw.shift > 0 {
+		s.checkSweep()
+	}
 }
 
-// Update the state of the channels sweep.
-func (chn *Channel) updateSweep() {
-	if chn.sweepStep < chn.sweepSteps {
-		t := sweepTimes[chn.sweepStepLen]
-		chn.sweepTime += perSample
-		if chn.sweepTime > t {
-			chn.sweepTime -= t
-			chn.sweepStep += 1
+func (s *square) calcSweep() uint16 {
+	delta := s.sweep.shadow >> s.sweep.shift
+	if s.sweep.negate {
+		s.sweep.negated = true
+		return s.sweep.shadow - delta
+	}
+	return s.sweep.shadow + delta
+}
 
-			if chn.sweepIncrease {
-				chn.frequency += chn.frequency / math.Pow(2, float64(chn.sweepStep))
-			} else {
-				chn.frequency -= chn.frequency / math.Pow(2, float64(chn.sweepStep))
-			}
+// checkSweep disables channel if new frequency overflows
+func (s *square) checkSweep() uint16 {
+	freq := s.calcSweep()
+	if freq > 2047 {
+		s.enabled = false
+	}
+	return freq
+}
+
+func (s *square) clockSweep() {
+	sw := &s.sweep
+	if sw.timer > 0 {
+		sw.timer--
+	}
+	if sw.timer > 0 {
+		return
+	}
+
+	sw.timer = sw.period
+	if sw.timer == 0 {
+		sw.timer = 8
+	}
+	if sw.enabled && sw.period > 0 {
+		freq := s.checkSweep()
+		if freq <= 2047 && sw.shift > 0 {
+			sw.shadow, s.freq = freq, freq
+			s.checkSweep()
 		}
 	}
 }
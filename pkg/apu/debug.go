@@ -0,0 +1,158 @@
+package apu
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	ScopeLen      = 128 // samples per channel shown in oscilloscope
+	scopeInterval = 128 // T-cycles between samples (about 4ms is shown)
+)
+
+// scope - recent digital outputs of each channel for debug oscilloscope
+type scope struct {
+	buf  [4][ScopeLen * 2]byte // ring buffer. twice as long as shown to find trigger point
+	pos  int
+	tick int // T-cycles until next sample
+}
+
+// EnableScope starts recording channel outputs for Scope
+func (a *APU) EnableScope() {
+	a.scope = &scope{tick: scopeInterval}
+}
+
+func (s *scope) advance(a *APU, cycles int) {
+	s.tick -= cycles
+	if s.tick > 0 {
+		return
+	}
+	s.tick += scopeInterval
+
+	for i, out := range [4]byte{a.chn1.output(), a.chn2.output(), a.chn3.output(), a.chn4.output()} {
+		s.buf[i][s.pos] = out
+	}
+	s.pos = (s.pos + 1) % len(s.buf[0])
+}
+
+// Scope returns ScopeLen samples(0-15) of channel ${ch}(1-4) starting from a rising edge
+func (a *APU) Scope(ch int) []byte {
+	result := make([]byte, ScopeLen)
+	if a.scope == nil {
+		return result
+	}
+
+	s := &a.scope.buf[ch-1]
+	n := len(s)
+	start := 0 // search trigger point in the older half
+	for i := 1; i < ScopeLen; i++ {
+		prev, cur := s[(a.scope.pos+i-1)%n], s[(a.scope.pos+i)%n]
+		if cur > prev {
+			start = i
+			break
+		}
+	}
+	for i := range result {
+		result[i] = s[(a.scope.pos+start+i)%n]
+	}
+	return result
+}
+
+// Muted returns whether channel ${ch}(1-4) is turned off by ToggleSoundChannel
+func (a *APU) Muted(ch int) bool {
+	return a.channel(ch).debugOff
+}
+
+func (a *APU) channel(ch int) *channel {
+	return [4]*channel{&a.chn1.channel, &a.chn2.channel, &a.chn3.channel, &a.chn4.channel}[ch-1]
+}
+
+// WaveRAM returns wave RAM (0xFF30-0xFF3F)
+func (a *APU) WaveRAM() [16]byte {
+	return a.chn3.ram
+}
+
+var noteNames = [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+func noteName(freq float64) string {
+	note := int(math.Round(midiPitch(freq)))
+	if note < 0 || note > 127 {
+		return "-"
+	}
+	return fmt.Sprintf("%s%d", noteNames[note%12], note/12-1)
+}
+
+func (ch *channel) debugString(name string) string {
+	state := "OFF"
+	if ch.enabled {
+		state = "ON "
+	}
+	if !ch.dac {
+		state = "DAC OFF"
+	}
+	pan := [2]string{"-", "-"}
+	if ch.left {
+		pan[0] = "L"
+	}
+	if ch.right {
+		pan[1] = "R"
+	}
+	length := "-"
+	if ch.length.enabled {
+		length = fmt.Sprint(ch.length.counter)
+	}
+	return fmt.Sprintf("%s %s %s%s len:%s", name, state, pan[0], pan[1], length)
+}
+
+func (e *envelope) debugString() string {
+	dir := "-"
+	if e.increase {
+		dir = "+"
+	}
+	return fmt.Sprintf("vol:%d env:%s%d", e.volume, dir, e.period)
+}
+
+// ChannelInfo returns decoded state of channel ${ch}(1-4)
+func (a *APU) ChannelInfo(ch int) string {
+	switch ch {
+	case 1, 2:
+		s := &a.chn1
+		if ch == 2 {
+			s = &a.chn2
+		}
+		freq := 131072 / float64(2048-int(s.freq))
+		info := fmt.Sprintf("%s\nduty:%s %.1fHz %s\n%s", s.channel.debugString(fmt.Sprintf("CH%d", ch)),
+			[4]string{"12.5%", "25%", "50%", "75%"}[s.duty], freq, noteName(freq), s.envelope.debugString())
+		if ch == 1 {
+			dir := "+"
+			if s.sweep.negate {
+				dir = "-"
+			}
+			info += fmt.Sprintf(" sweep:%d %s%d", s.sweep.period, dir, s.sweep.shift)
+		}
+		return info
+	case 3:
+		w := &a.chn3
+		freq := 65536 / float64(2048-int(w.freq))
+		return fmt.Sprintf("%s\n%.1fHz %s\nvol:%s", w.channel.debugString("CH3"), freq, noteName(freq),
+			[4]string{"0%", "100%", "50%", "25%"}[w.volumeCode])
+	case 4:
+		n := &a.chn4
+		width := 15
+		if n.width7 {
+			width = 7
+		}
+		return fmt.Sprintf("%s\n%.1fHz %dbit\n%s", n.channel.debugString("CH4"),
+			float64(cpuClock)/float64(n.period()), width, n.envelope.debugString())
+	}
+	return ""
+}
+
+// ControlInfo returns decoded NR50-NR52
+func (a *APU) ControlInfo() string {
+	power := "OFF"
+	if a.power {
+		power = "ON"
+	}
+	return fmt.Sprintf("NR50 L:%d R:%d  NR51:%02x  NR52 power:%s", a.lVol, a.rVol, a.memory[0x25], power)
+}
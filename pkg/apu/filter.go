@@ -0,0 +1,45 @@
+package apu
+
+import "math"
+
+// capacitor charge factor per T-cycle
+const (
+	dmgCharge = 0.999958
+	cgbCharge = 0.998943
+)
+
+// filter - high-pass filter of the capacitors on audio output, and optional low-pass filter like a small speaker
+type filter struct {
+	charge  float64 // capacitor charge factor per sample (0: disabled)
+	lowpass float64 // smoothing factor per sample (0: disabled)
+	cap     [2]float64
+	lp      [2]float64
+}
+
+func newFilter(s Settings, cgb bool) filter {
+	var f filter
+	if s.HighPass {
+		charge := dmgCharge
+		if cgb {
+			charge = cgbCharge
+		}
+		f.charge = math.Pow(charge, cpuClock/float64(s.SampleRate))
+	}
+	if s.LowPass > 0 {
+		f.lowpass = 1 - math.Exp(-2*math.Pi*s.LowPass/float64(s.SampleRate))
+	}
+	return f
+}
+
+func (f *filter) apply(ch int, v float64) float64 {
+	if f.charge != 0 {
+		out := v - f.cap[ch]
+		f.cap[ch] = v - out*f.charge
+		v = out
+	}
+	if f.lowpass != 0 {
+		f.lp[ch] += f.lowpass * (v - f.lp[ch])
+		v = f.lp[ch]
+	}
+	return v
+}
@@ -0,0 +1,216 @@
+package apu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+const (
+	midiDivision       = 480    // ticks per quarter note
+	midiTempo          = 500000 // microseconds per quarter note (120bpm)
+	midiTicksPerSecond = midiDivision * 1000000 / midiTempo
+	midiBendRange      = 2 // semitones (GM default)
+	midiDrumChannel    = 9 // channel 4 is recorded as GM percussion
+)
+
+// voice - channel state which MIDI recorder follows
+type voice struct {
+	on     bool
+	freq   float64 // Hz
+	volume byte    // 0-15
+	drum   byte    // note number of percussion (channel 4)
+}
+
+func (a *APU) voices() [4]voice {
+	square := func(s *square) voice {
+		return voice{on: s.enabled && s.dac, freq: 131072 / float64(2048-int(s.freq)), volume: s.volume}
+	}
+	w, n := &a.chn3, &a.chn4
+
+	drum := byte(38) // acoustic snare
+	switch {
+	case n.width7:
+		drum = 42 // closed hi-hat
+	case n.period() < 64:
+		drum = 46 // open hi-hat
+	}
+
+	return [4]voice{
+		square(&a.chn1),
+		square(&a.chn2),
+		{on: w.enabled && w.dac, freq: 65536 / float64(2048-int(w.freq)), volume: 15 >> waveShift[w.volumeCode]},
+		{on: n.enabled && n.dac, volume: n.volume, drum: drum},
+	}
+}
+
+// events notifies MIDI recorder of register changes
+func (a *APU) events() {
+	if a.midi != nil {
+		a.midi.update(a.cycles, a.voices(), a.triggered)
+	}
+	a.triggered = 0
+}
+
+// RecordMIDI records note events of each channel into Standard MIDI File
+func (a *APU) RecordMIDI(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	a.midi = newMIDIRecorder(f)
+	return nil
+}
+
+// midiRecorder - channel 1-3 are recorded as MIDI channel 0-2, channel 4 as percussion
+// Frequency changes within bend range (e.g. sweep) are pitch bends, otherwise notes are played again.
+type midiRecorder struct {
+	file  *os.File
+	track bytes.Buffer
+	tick  uint64 // tick of the last event
+	notes [4]midiNote
+}
+
+type midiNote struct {
+	on     bool
+	note   byte
+	freq   float64
+	volume byte
+}
+
+func newMIDIRecorder(f *os.File) *midiRecorder {
+	m := &midiRecorder{file: f}
+	m.track.Write([]byte{0x00, 0xff, 0x51, 0x03, midiTempo >> 16, midiTempo >> 8 & 0xff, midiTempo & 0xff})
+	for ch, program := range []byte{80, 80, 81} { // square lead, square lead, sawtooth lead
+		m.event(0, 0xc0|byte(ch), program)
+	}
+	return m
+}
+
+// midiPitch converts frequency into MIDI note number (A4=69)
+func midiPitch(freq float64) float64 {
+	return 69 + 12*math.Log2(freq/440)
+}
+
+func (m *midiRecorder) update(cycles uint64, voices [4]voice, triggered byte) {
+	tick := cycles * midiTicksPerSecond / cpuClock
+	for i, v := range voices {
+		n := &m.notes[i]
+		retrigger := triggered&(1<<i) != 0
+		if n.on && (!v.on || retrigger) {
+			m.noteOff(tick, i)
+		}
+		if !v.on {
+			continue
+		}
+		if !n.on {
+			if retrigger {
+				m.noteOn(tick, i, v)
+			}
+			continue
+		}
+
+		if i != 3 && v.freq != n.freq {
+			offset := midiPitch(v.freq) - float64(n.note)
+			if math.Abs(offset) > midiBendRange {
+				m.noteOff(tick, i)
+				m.noteOn(tick, i, v)
+				continue
+			}
+			m.pitchBend(tick, i, offset)
+			n.freq = v.freq
+		}
+		if v.volume != n.volume {
+			m.expression(tick, i, v.volume)
+		}
+	}
+}
+
+func midiChannel(i int) byte {
+	if i == 3 {
+		return midiDrumChannel
+	}
+	return byte(i)
+}
+
+func (m *midiRecorder) noteOn(tick uint64, i int, v voice) {
+	n := &m.notes[i]
+	n.on, n.freq = true, v.freq
+	if i == 3 {
+		n.note = v.drum
+	} else {
+		pitch := math.Round(midiPitch(v.freq))
+		n.note = byte(math.Max(0, math.Min(127, pitch)))
+		m.pitchBend(tick, i, midiPitch(v.freq)-float64(n.note))
+	}
+	m.expression(tick, i, v.volume)
+	m.event(tick, 0x90|midiChannel(i), n.note, 100)
+}
+
+func (m *midiRecorder) noteOff(tick uint64, i int) {
+	n := &m.notes[i]
+	n.on = false
+	m.event(tick, 0x80|midiChannel(i), n.note, 0)
+}
+
+// pitchBend bends by ${offset} semitones
+func (m *midiRecorder) pitchBend(tick uint64, i int, offset float64) {
+	if i == 3 {
+		return
+	}
+	value := int(8192 + offset/midiBendRange*8192)
+	if value < 0 {
+		value = 0
+	} else if value > 16383 {
+		value = 16383
+	}
+	m.event(tick, 0xe0|midiChannel(i), byte(value&0x7f), byte(value>>7))
+}
+
+// expression (CC11) follows channel volume
+func (m *midiRecorder) expression(tick uint64, i int, volume byte) {
+	m.notes[i].volume = volume
+	m.event(tick, 0xb0|midiChannel(i), 11, byte(int(volume)*127/15))
+}
+
+func (m *midiRecorder) event(tick uint64, data ...byte) {
+	delta := tick - m.tick
+	m.tick = tick
+
+	// variable length quantity
+	var vlq [10]byte
+	i := len(vlq) - 1
+	vlq[i] = byte(delta & 0x7f)
+	for delta >>= 7; delta > 0; delta >>= 7 {
+		i--
+		vlq[i] = 0x80 | byte(delta&0x7f)
+	}
+	m.track.Write(vlq[i:])
+	m.track.Write(data)
+}
+
+// close writes Standard MIDI File (format 0)
+func (m *midiRecorder) close(cycles uint64) error {
+	tick := cycles * midiTicksPerSecond / cpuClock
+	for i := range m.notes {
+		if m.notes[i].on {
+			m.noteOff(tick, i)
+		}
+	}
+	m.event(tick, 0xff, 0x2f, 0x00) // end of track
+
+	var buf bytes.Buffer
+	buf.WriteString("MThd")
+	binary.Write(&buf, binary.BigEndian, uint32(6))
+	binary.Write(&buf, binary.BigEndian, []uint16{0, 1, midiDivision})
+	buf.WriteString("MTrk")
+	binary.Write(&buf, binary.BigEndian, uint32(m.track.Len()))
+	buf.Write(m.track.Bytes())
+
+	if _, err := m.file.Write(buf.Bytes()); err != nil {
+		m.file.Close()
+		return err
+	}
+	return m.file.Close()
+}
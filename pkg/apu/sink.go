@@ -0,0 +1,181 @@
+package apu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"time"
+
+	"github.com/hajimehoshi/oto"
+)
+
+// AudioSink receives interleaved 16bit stereo samples at APU sample rate
+type AudioSink interface {
+	Write(samples []int16)
+	Close() error
+}
+
+const mixMask = 0x0f // all channels
+
+// output - blip buffer of channels ${mask} and its destination
+type output struct {
+	mask   byte // bit0-3: channel 1-4
+	blip   blip
+	filter filter
+	sink   AudioSink
+}
+
+// buffered is implemented by sinks which play samples in real time
+type buffered interface {
+	fill() float64 // 0-1
+}
+
+// realtime returns the sink which plays samples in real time
+func realtime(sink AudioSink) (buffered, bool) {
+	switch s := sink.(type) {
+	case buffered:
+		return s, true
+	case multiSink:
+		for _, sink := range s {
+			if b, ok := realtime(sink); ok {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// NullSink discards samples
+type NullSink struct{}
+
+func (NullSink) Write(samples []int16) {}
+func (NullSink) Close() error          { return nil }
+
+// otoSink plays samples on audio device
+type otoSink struct {
+	context *oto.Context
+	player  *oto.Player
+	stream  *stream
+	frames  int // stereo samples written to audio device at once
+}
+
+// NewOtoSink opens audio device and starts playback with ${latency}
+func NewOtoSink(sampleRate int, latency time.Duration) (AudioSink, error) {
+	s := &otoSink{stream: newStream(sampleRate, latency)}
+	s.frames = len(s.stream.buf) / 2 / 8
+	context, err := oto.NewContext(sampleRate, 2, 2, s.frames*2*2*2)
+	if err != nil {
+		return nil, err
+	}
+	s.context, s.player = context, context.NewPlayer()
+	go s.play()
+	return s, nil
+}
+
+func (s *otoSink) Write(samples []int16) { s.stream.write(samples) }
+func (s *otoSink) fill() float64         { return s.stream.fill() }
+
+func (s *otoSink) Close() error {
+	s.player.Close()
+	return s.context.Close()
+}
+
+// play writes samples to audio device.
+// Player.Write blocks until the audio device consumes samples, so it paces itself.
+func (s *otoSink) play() {
+	samples := make([]int16, s.frames*2)
+	data := make([]byte, len(samples)*2)
+	var last [2]int16
+	for {
+		n := s.stream.read(samples)
+		if n >= 2 {
+			last = [2]int16{samples[n-2], samples[n-1]}
+		}
+		for i := n; i < len(samples); i += 2 { // underrun: hold the last level to avoid clicks
+			samples[i], samples[i+1] = last[0], last[1]
+		}
+
+		for i, v := range samples {
+			binary.LittleEndian.PutUint16(data[2*i:], uint16(v))
+		}
+		if _, err := s.player.Write(data); err != nil { // closed
+			return
+		}
+	}
+}
+
+const wavHeaderSize = 44
+
+// WAVSink writes samples into .wav file
+type WAVSink struct {
+	file       *os.File
+	w          *bufio.Writer
+	sampleRate uint32
+	size       uint32 // bytes of sample data
+}
+
+// NewWAVSink creates .wav file
+func NewWAVSink(path string, sampleRate int) (*WAVSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &WAVSink{file: f, w: bufio.NewWriter(f), sampleRate: uint32(sampleRate)}
+	s.w.Write(s.header())
+	return s, nil
+}
+
+// header is written again with the actual sizes on Close
+func (s *WAVSink) header() []byte {
+	const channels, bits = 2, 16
+	h := make([]byte, wavHeaderSize)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+s.size)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(h[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(h[22:], channels)
+	binary.LittleEndian.PutUint32(h[24:], s.sampleRate)
+	binary.LittleEndian.PutUint32(h[28:], s.sampleRate*channels*bits/8)
+	binary.LittleEndian.PutUint16(h[32:], channels*bits/8)
+	binary.LittleEndian.PutUint16(h[34:], bits)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], s.size)
+	return h
+}
+
+func (s *WAVSink) Write(samples []int16) {
+	binary.Write(s.w, binary.LittleEndian, samples)
+	s.size += uint32(len(samples) * 2)
+}
+
+// Close fixes up the header and closes the file
+func (s *WAVSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	if _, err := s.file.WriteAt(s.header(), 0); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// multiSink writes samples to all sinks
+type multiSink []AudioSink
+
+func (m multiSink) Write(samples []int16) {
+	for _, s := range m {
+		s.Write(samples)
+	}
+}
+
+func (m multiSink) Close() (err error) {
+	for _, s := range m {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
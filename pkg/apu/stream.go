@@ -0,0 +1,87 @@
+package apu
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	cpuClock = 4194304 // APU is clocked at 4MHz even in double speed mode
+
+	// Update runs 60 times per second and each runs 1 frame (70224 cycles),
+	// so emulated clock is slightly faster than real hardware.
+	emulatedClock = 70224 * 60
+
+	// Output rate is adjusted by up to ±0.5% to keep the stream half full.
+	maxRateDelta = 0.005
+)
+
+// stream - ring buffer of interleaved 16bit stereo samples between emulation and audio device
+type stream struct {
+	mutex sync.Mutex
+	buf   []int16
+	r, n  int
+}
+
+// newStream holds ${latency} of samples when half full
+func newStream(sampleRate int, latency time.Duration) *stream {
+	frames := int(2 * latency * time.Duration(sampleRate) / time.Second)
+	return &stream{buf: make([]int16, frames*2)}
+}
+
+// write drops samples which don't fit
+func (s *stream) write(samples []int16) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, v := range samples {
+		if s.n == len(s.buf) {
+			return
+		}
+		s.buf[(s.r+s.n)%len(s.buf)] = v
+		s.n++
+	}
+}
+
+// read fills ${out} and returns the number of samples read
+func (s *stream) read(out []int16) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := len(out)
+	if n > s.n {
+		n = s.n
+	}
+	for i := 0; i < n; i++ {
+		out[i] = s.buf[(s.r+i)%len(s.buf)]
+	}
+	s.r = (s.r + n) % len(s.buf)
+	s.n -= n
+	return n
+}
+
+// fill returns fill level (0-1)
+func (s *stream) fill() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return float64(s.n) / float64(len(s.buf))
+}
+
+// outputRate returns output samples per cycle.
+// When samples are played in real time, dynamic rate control produces a little more samples
+// while the playback stream is less than half full, and vice versa.
+func (a *APU) outputRate() float64 {
+	for _, o := range a.outputs {
+		if b, ok := realtime(o.sink); ok {
+			return float64(a.settings.SampleRate) / emulatedClock * (1 + maxRateDelta*(1-2*b.fill()))
+		}
+	}
+	return float64(a.settings.SampleRate) / cpuClock // offline recording runs in emulated time
+}
+
+// flush moves completed samples from blip buffers to sinks
+func (a *APU) flush() {
+	for _, o := range a.outputs {
+		a.samples = o.blip.read(a.samples[:0], &o.filter)
+		o.sink.Write(a.samples)
+	}
+	a.rate = a.outputRate()
+}
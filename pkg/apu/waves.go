@@ -1,44 +1,94 @@
 package apu
 
-import (
-	"math"
-	"math/rand"
-)
-
-// WaveGenerator is a function which can be used for generating waveform
-// samples for different channels.
-type WaveGenerator func(t float64) byte
-
-// Square returns a square wave generator with a given mod. This is used
-// for channels 1 and 2.
-func Square(mod float64) WaveGenerator {
-	return func(t float64) byte {
-		if math.Sin(t) <= mod {
-			return 0xFF
-		}
-		return 0
+// wave - channel 3 plays 32 4bit samples in wave RAM
+type wave struct {
+	channel
+
+	volumeCode byte
+	freq       uint16 // 11bit
+	timer      int
+	pos        byte // 0-31
+	sample     byte // sample buffer
+	sinceRead  int  // cycles since the last wave RAM read
+	ram        [16]byte
+}
+
+// volume code => right shift
+var waveShift = [4]byte{4, 0, 1, 2}
+
+func (w *wave) step(cycles int) {
+	w.timer -= cycles
+	w.sinceRead += cycles
+	if w.timer > 0 {
+		return
 	}
+	period := (2048 - int(w.freq)) * 2
+	n := (-w.timer)/period + 1
+	w.timer += n * period
+	w.sinceRead = period - w.timer
+	w.pos = byte((int(w.pos) + n) & 31)
+	w.sample = w.ram[w.pos/2]
+	if w.pos%2 == 0 {
+		w.sample >>= 4
+	}
+	w.sample &= 0x0f
 }
 
-// Waveform returns a wave generator for some waveform ram. This is used
-// by channel 3.
-func Waveform(ram func(i int) byte) WaveGenerator {
-	return func(t float64) byte {
-		idx := int(math.Floor(t/twoPi*32)) % 0x20
-		return ram(idx)
+func (w *wave) output() byte {
+	if !w.enabled {
+		return 0
 	}
+	return w.sample >> waveShift[w.volumeCode]
+}
+
+func (w *wave) trigger() {
+	w.timer = (2048 - int(w.freq)) * 2
+	w.pos = 0
+	w.sinceRead = 2 // wave RAM isn't read on trigger
 }
 
-// Noise returns a wave generator for a noise channel. This is used by
-// channel 4.
-func Noise() WaveGenerator {
-	var last float64
-	var val byte
-	return func(t float64) byte {
-		if t-last > twoPi {
-			last = t
-			val = byte(rand.Intn(2)) * 0xFF
+// noise - channel 4 outputs bit0 of LFSR
+type noise struct {
+	channel
+	envelope
+
+	shift   byte // clock shift
+	width7  bool // 7bit LFSR
+	divisor byte
+	timer   int
+	lfsr    uint16 // 15bit
+}
+
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+func (n *noise) period() int {
+	return noiseDivisors[n.divisor] << n.shift
+}
+
+func (n *noise) step(cycles int) {
+	if n.shift >= 14 { // LFSR isn't clocked
+		return
+	}
+	n.timer -= cycles
+	for n.timer <= 0 {
+		n.timer += n.period()
+		bit := (n.lfsr ^ (n.lfsr >> 1)) & 1
+		n.lfsr = (n.lfsr >> 1) | (bit << 14)
+		if n.width7 {
+			n.lfsr = (n.lfsr &^ (1 << 6)) | (bit << 6)
 		}
-		return val
 	}
 }
+
+func (n *noise) output() byte {
+	if !n.enabled {
+		return 0
+	}
+	return byte(^n.lfsr&1) * n.volume
+}
+
+func (n *noise) trigger() {
+	n.timer = n.period()
+	n.lfsr = 0x7fff
+	n.envelope.trigger()
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
 
 	"github.com/BurntSushi/toml"
 )
@@ -10,12 +12,22 @@ const (
 	tomlName = "worldwide.toml"
 )
 
+// ranges of [audio] values
+const (
+	minSampleRate, maxSampleRate = 8000, 192000 // Hz
+	minLatency, maxLatency       = 10, 1000     // ms
+)
+
 // Config for emulator
 type Config struct {
 	Display Display `toml:"display"`
 	Palette Palette `toml:"palette"`
+	Serial  Serial  `toml:"serial"`
 	Network Network `toml:"network"`
+	RTC     RTC     `toml:"rtc"`
+	Save    Save    `toml:"save"`
 	Joypad  Joypad  `toml:"joypad"`
+	Audio   Audio   `toml:"audio"`
 	Debug   Debug   `toml:"debug"`
 }
 
@@ -33,11 +45,31 @@ type Palette struct {
 	Color3 [3]int `toml:"color3"`
 }
 
+// Serial config
+type Serial struct {
+	Device   string `toml:"device"`    // none, loopback, stdout, printer, tcp, relay, dmg07
+	PrintDir string `toml:"print_dir"` // Game Boy Printer saves pages here
+}
+
 // Network config
 type Network struct {
 	Network bool   `toml:"network"`
 	Your    string `toml:"your"`
 	Peer    string `toml:"peer"`
+	Relay   string `toml:"relay"` // relay server for link over the internet
+	Room    string `toml:"room"`  // room code shared with the peer on relay server
+}
+
+// RTC config
+type RTC struct {
+	SyncHost bool `toml:"sync_host"` // on load, advance clock by real time passed since the game was saved
+}
+
+// Save config
+type Save struct {
+	Dir      string `toml:"save_dir"` // directory of save data. empty: the same directory as ROM
+	Backups  int    `toml:"backups"`  // ${name}.sav.bak1 ~ bak${backups} keep save data of the previous sessions
+	Autosave int    `toml:"autosave"` // save after SRAM has been dirty for seconds. 0: only on exit
 }
 
 // Joypad config
@@ -49,6 +81,43 @@ type Joypad struct {
 	Threshold float64 `toml:"threshold"`
 }
 
+// Audio config
+type Audio struct {
+	Volume     float64 `toml:"volume"`      // 0-1
+	Latency    int     `toml:"latency"`     // ms
+	SampleRate int     `toml:"sample_rate"` // Hz
+	HighPass   bool    `toml:"highpass"`    // true: emulate capacitors on output
+	LowPass    float64 `toml:"lowpass"`     // cutoff frequency(Hz) of speaker filter, 0: disable
+}
+
+// clamp replaces values out of range with the nearest valid ones and returns what is replaced
+func (a *Audio) clamp() (warnings []string) {
+	if !(a.Volume >= 0 && a.Volume <= 1) { // NaN too
+		volume := 0.0
+		if a.Volume > 1 {
+			volume = 1
+		}
+		warnings = append(warnings, fmt.Sprintf("volume = %g is out of range (0-1), %g is used", a.Volume, volume))
+		a.Volume = volume
+	}
+	clampInt := func(name string, value *int, min, max int) {
+		v := *value
+		switch {
+		case v < min:
+			v = min
+		case v > max:
+			v = max
+		default:
+			return
+		}
+		warnings = append(warnings, fmt.Sprintf("%s = %d is out of range (%d-%d), %d is used", name, *value, min, max, v))
+		*value = v
+	}
+	clampInt("sample_rate", &a.SampleRate, minSampleRate, maxSampleRate)
+	clampInt("latency", &a.Latency, minLatency, maxLatency)
+	return warnings
+}
+
 // Debug config
 type Debug struct {
 	BreakPoints []string `toml:"breakpoints"`
@@ -56,10 +125,18 @@ type Debug struct {
 }
 
 func Init() *Config {
-	cfg := &Config{}
+	cfg := &Config{
+		Serial: Serial{PrintDir: "prints"},
+		RTC:    RTC{SyncHost: true},
+		Save:   Save{Backups: 3, Autosave: 5},
+		Audio:  Audio{Volume: 0.25, Latency: 50, SampleRate: 44100, HighPass: true}, // for config without [audio]
+	}
 
 	// load config
 	if _, err := toml.DecodeFile(tomlName, cfg); err == nil {
+		for _, warning := range cfg.Audio.clamp() {
+			fmt.Fprintf(os.Stderr, "Config Error: [audio] %s\n", warning)
+		}
 		return cfg
 	}
 
@@ -75,10 +152,24 @@ color1 = [93, 147, 66]
 color2 = [22, 63, 48]
 color3 = [0, 40, 0]
 
+[serial]
+device = "none" # link port device: none, loopback, stdout, printer, tcp, relay, dmg07
+print_dir = "prints" # Game Boy Printer saves PNG here
+
 [network]
-network = false
+network = false # same as device = "tcp"
 your = "127.0.0.1:8888"
 peer = "127.0.0.1:9999"
+relay = "127.0.0.1:7777" # worldwide-relay server
+room = ""
+
+[rtc]
+sync_host = true # on load, advance clock by real time passed since the game was saved. false: clock runs only in emulation
+
+[save]
+save_dir = "" # directory of save data. empty: the same directory as ROM
+backups = 3 # number of backups of the previous sessions
+autosave = 5 # save after SRAM has been dirty for seconds. 0: only on exit
 
 [joypad]
 A = 1
@@ -87,6 +178,13 @@ Start = 7
 Select = 6
 threshold = 0.7 # How reactive axis is
 
+[audio]
+volume = 0.25 # master volume (0-1)
+latency = 50 # ms (10-1000)
+sample_rate = 44100 # Hz (8000-192000)
+highpass = true # emulate capacitors on output like real hardware
+lowpass = 0.0 # cutoff frequency(Hz) of speaker filter, 0 disables
+
 [debug]
 # "BANK:PC;Cond" e.g. "00:0460;SP==c0f3", "01:ffff;"
 breakpoints = []
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+// chdirTemp changes working directory into temporary directory because config is created there
+func chdirTemp(t *testing.T) {
+	cur, _ := os.Getwd()
+	tmp, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chdir(tmp)
+	t.Cleanup(func() {
+		os.Chdir(cur)
+		os.RemoveAll(tmp)
+	})
+}
+
+func TestInit(t *testing.T) {
+	chdirTemp(t)
+	created := Init()
+
+	// every value in the created config must be decoded, otherwise it is overwritten on every launch
+	loaded := &Config{}
+	if _, err := toml.DecodeFile(tomlName, loaded); err != nil {
+		t.Fatalf("created config can't be decoded: %s", err)
+	}
+	if loaded.Palette.Color0 == [3]int{} || loaded.Audio.SampleRate == 0 {
+		t.Errorf("palette %v, audio %+v are not decoded", loaded.Palette, loaded.Audio)
+	}
+	if again := Init(); again.Palette != created.Palette || again.Audio != created.Audio {
+		t.Errorf("loaded config differs from created one: %+v, %+v", again.Audio, created.Audio)
+	}
+}
+
+func TestAudioClamp(t *testing.T) {
+	valid := Audio{Volume: 0.25, Latency: 50, SampleRate: 44100}
+	tests := []struct {
+		name     string
+		audio    Audio
+		want     Audio
+		warnings int
+	}{
+		{"valid", valid, valid, 0},
+		{"loud", Audio{Volume: 3, Latency: 50, SampleRate: 44100}, Audio{Volume: 1, Latency: 50, SampleRate: 44100}, 1},
+		{"negative volume", Audio{Volume: -1, Latency: 50, SampleRate: 44100}, Audio{Volume: 0, Latency: 50, SampleRate: 44100}, 1},
+		{"huge sample rate", Audio{Volume: 0.25, Latency: 50, SampleRate: 1 << 30}, Audio{Volume: 0.25, Latency: 50, SampleRate: maxSampleRate}, 1},
+		{"zero sample rate", Audio{Volume: 0.25, Latency: 50, SampleRate: 0}, Audio{Volume: 0.25, Latency: 50, SampleRate: minSampleRate}, 1},
+		{"zero latency", Audio{Volume: 0.25, Latency: 0, SampleRate: 44100}, Audio{Volume: 0.25, Latency: minLatency, SampleRate: 44100}, 1},
+		{"all", Audio{Volume: 2, Latency: -5, SampleRate: -1}, Audio{Volume: 1, Latency: minLatency, SampleRate: minSampleRate}, 3},
+	}
+	for _, tt := range tests {
+		audio := tt.audio
+		warnings := audio.clamp()
+		if audio != tt.want || len(warnings) != tt.warnings {
+			t.Errorf("%s: %+v with %d warnings %q, want %+v with %d", tt.name, audio, len(warnings), warnings, tt.want, tt.warnings)
+		}
+	}
+}
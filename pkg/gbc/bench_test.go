@@ -0,0 +1,30 @@
+package gbc
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkTestROM runs each test ROM in test/ headless for 10 seconds of emulated time
+func BenchmarkTestROM(b *testing.B) {
+	paths, names := testROMs(b)
+	tmp := chdirTemp(b)
+	for i, path := range paths {
+		rom, err := ioutil.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(names[i], func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				cpu := newTestCPU(b, rom, tmp)
+				b.StartTimer()
+
+				if err := cpu.DebugExec(60*10, filepath.Join(tmp, "actual.jpg")); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
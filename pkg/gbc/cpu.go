@@ -1,10 +1,11 @@
 package gbc
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"math"
-	"net"
-	"sync"
+	"os"
+	"time"
 
 	"gbc/pkg/apu"
 	"gbc/pkg/cartridge"
@@ -42,32 +43,50 @@ type WRAMBank struct {
 
 // CPU Central Processing Unit
 type CPU struct {
-	Reg       Register
-	RAM       [0x10000]byte
-	Cartridge cartridge.Cartridge
-	mutex     sync.Mutex
-	joypad    joypad.Joypad
-	halt      bool // Halt状態か
-	Config    *config.Config
-	mode      int
+	Reg        Register
+	RAM        [0x10000]byte
+	Cartridge  cartridge.Cartridge
+	romHash    [32]byte // sha256 of ROM file
+	joypad     joypad.Joypad
+	halt       bool // Halt状態か
+	haltBug    bool // PC isn't incremented on the next fetch
+	stopped    bool // STOP状態か
+	locked     bool // invalid opcode hangs CPU
+	Config     *config.Config
+	mode       int
+	lineEnd    bool    // scanline is completed
+	lineScroll [2]uint // scroll position at the end of LCD mode
 	// timer関連
 	Timer
-	serialTick chan int
+	scheduler Scheduler
 	ROMBank
 	RAMBank
 	WRAMBank
 	bankMode uint
+	memory   MemoryMap
+	HDMA
 	// サウンド
-	Sound apu.APU
+	Sound   apu.APU
+	soundAt uint64 // cycle APU has been run until
 	// 画面
-	GPU gpu.GPU
+	GPU   gpu.GPU
+	frame frame
 	// RTC
 	RTC   rtc.RTC
-	boost int // 倍速か
+	rtcAt uint64 // cycle RTC has been run until
+	boost int    // 倍速か
+	// M-cycles in normal speed until the cycle normalAt. It's the clock which doesn't depend on double speed mode.
+	normal   uint64
+	normalAt uint64
 	// シリアル通信
-	Serial serial.Serial
+	Serial   serial.Serial
+	Infrared serial.Infrared
 
-	romdir string // ロムがあるところのディレクトリパス
+	romdir  string // ロムがあるところのディレクトリパス
+	ROMName string // ROM file name without extension. Save data is named after it.
+	sram    sram
+	gbs     *GBS // GBS player mode
+	Player  int  // 1 or 2 in split screen, 0 otherwise
 
 	IMESwitch
 	debug Debug
@@ -75,7 +94,9 @@ type CPU struct {
 
 // TransferROM Transfer ROM from cartridge to Memory
 func (cpu *CPU) TransferROM(rom []byte) {
-	for i := 0x0000; i <= 0x7fff; i++ {
+	cpu.romHash = sha256.Sum256(rom)
+	// only bank0 is copied. 0x4000-0x7fff in RAM is unused because memory.read maps it to ROMBank.bank[ptr].
+	for i := 0x0000; i <= 0x3fff; i++ {
 		cpu.RAM[i] = rom[i]
 	}
 
@@ -143,7 +164,8 @@ func (cpu *CPU) TransferROM(rom []byte) {
 			panic(errorMsg)
 		}
 	case 0x0f, 0x10, 0x11, 0x12, 0x13: // Type : 0x0f, 0x10, 0x11, 0x12, 0x13 => MBC3
-		cpu.Cartridge.MBC, cpu.RTC.Enable = cartridge.MBC3, true
+		cpu.Cartridge.MBC = cartridge.MBC3
+		cpu.RTC.Enable = cpu.Cartridge.Type == 0x0f || cpu.Cartridge.Type == 0x10 // MBC3+TIMER
 		switch r := int(cpu.Cartridge.ROMSize); r {
 		case 0, 1, 2, 3, 4, 5, 6:
 			cpu.transferROM(int(math.Pow(2, float64(r+1))), rom)
@@ -212,45 +234,34 @@ func (cpu *CPU) initIOMap() {
 	cpu.RAM[OBP0IO], cpu.RAM[OBP1IO] = 0xff, 0xff
 }
 
-func (cpu *CPU) initNetwork() {
-	if cpu.Config.Network.Network {
-		your, peer := cpu.Config.Network.Your, cpu.Config.Network.Peer
-		myIP, myPort, _ := net.SplitHostPort(your)
-		peerIP, peerPort, _ := net.SplitHostPort(peer)
-		received := make(chan int)
-		cpu.Serial.Init(myIP, myPort, peerIP, peerPort, received, &cpu.mutex)
-		cpu.serialTick = make(chan int)
-
-		go func() {
-			for {
-				<-received
-				cpu.Serial.TransferFlag = 1
-				<-cpu.serialTick
-				cpu.Serial.Receive()
-				cpu.Serial.ClearSC()
-				cpu.setSerialFlag(true)
-			}
-		}()
-	}
-}
-
 func (cpu *CPU) initDMGPalette() {
 	c0, c1, c2, c3 := cpu.Config.Palette.Color0, cpu.Config.Palette.Color1, cpu.Config.Palette.Color2, cpu.Config.Palette.Color3
-	gpu.InitPalette(c0, c1, c2, c3)
+	cpu.GPU.InitPalette(c0, c1, c2, c3)
 }
 
 // Init cpu and ram
 func (cpu *CPU) Init(romdir string, debug bool, test bool) {
+	cpu.scheduler.init()
 	cpu.initRegister()
 	cpu.initIOMap()
 
 	cpu.ROMBank.ptr, cpu.WRAMBank.ptr = 1, 1
 
 	cpu.GPU.Init(debug)
+	cpu.initMemoryMap()
 	cpu.Config = config.Init()
 	cpu.boost = 1
+	cpu.setOAMRAMMode()
+	cpu.scheduler.schedule(eventPPU, 20*cpu.boost)
+	cpu.scheduleFrameSequencer()
 
-	cpu.initNetwork()
+	cpu.frame.second = time.Tick(time.Second)
+	if cpu.Player == 2 { // player 1 uses keyboard
+		cpu.joypad.Gamepad, cpu.joypad.NoKeyboard = 1, true
+	}
+	if cpu.Player == 0 { // players in split screen are linked with each other
+		cpu.initSerial()
+	}
 
 	if !cpu.Cartridge.IsCGB {
 		cpu.initDMGPalette()
@@ -258,16 +269,31 @@ func (cpu *CPU) Init(romdir string, debug bool, test bool) {
 
 	// load save data
 	cpu.romdir = romdir
+	cpu.RTC.SyncHost = cpu.Config.RTC.SyncHost
 	cpu.load()
 
 	// Init APU
-	cpu.Sound.Init(!test)
+	audio := cpu.Config.Audio
+	cpu.Sound.Init(cpu.Cartridge.IsCGB, apu.Settings{
+		Volume:     audio.Volume,
+		SampleRate: audio.SampleRate,
+		HighPass:   audio.HighPass,
+		LowPass:    audio.LowPass,
+	})
+	switch {
+	case test:
+		cpu.Sound.AddSink(apu.NullSink{}) // samples are generated and discarded like in normal play
+	case cpu.Player < 2: // only one audio device can be opened
+		cpu.initAudio()
+	}
 
-	// Init RTC
-	go cpu.RTC.Init()
+	if cpu.gbs != nil {
+		cpu.playTrack(int(cpu.gbs.First) - 1)
+	}
 
 	cpu.debug.on = debug
 	if debug {
+		cpu.Sound.EnableScope()
 		cpu.Config.Display.HQ2x, cpu.Config.Display.FPS30 = false, true
 		cpu.debug.history.SetFlag(cpu.Config.Debug.History)
 		cpu.debug.Break.ParseBreakpoints(cpu.Config.Debug.BreakPoints)
@@ -278,115 +304,109 @@ func (cpu *CPU) Init(romdir string, debug bool, test bool) {
 func (cpu *CPU) Exit() {
 	cpu.save()
 	cpu.Serial.Exit()
+	if err := cpu.Sound.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Audio Error: %s\n", err)
+	}
 }
 
-// Exec 1cycle
-func (cpu *CPU) exec(max int) {
+// Exec 1 instruction
+func (cpu *CPU) exec() {
+	if cpu.locked {
+		cpu.timer(cpu.scheduler.until())
+		return
+	}
+	if cpu.stopped {
+		if cpu.joypad.Output()&0x0f == 0x0f { // all clocks stop until joypad input
+			return
+		}
+		cpu.stopped = false
+	}
+	if cpu.HDMA.stall > 0 { // CPU is halted during VRAM DMA
+		stall := cpu.HDMA.stall
+		cpu.HDMA.stall = 0
+		cpu.timer(stall)
+	}
+
 	bank, PC := cpu.ROMBank.ptr, cpu.Reg.PC
 
-	bytecode := cpu.FetchMemory8(PC)
-	opcode := opcodes[bytecode]
-	instruction, operand1, operand2, cycle, handler := opcode.Ins, opcode.Operand1, opcode.Operand2, opcode.Cycle1, opcode.Handler
+	bytecode := uint16(cpu.FetchMemory8(PC))
+	opcode := &opcodes[bytecode]
+	cycle := opcode.Cycle1
 
 	if !cpu.halt {
-		if cpu.debug.on && cpu.debug.history.Flag() {
-			cpu.debug.history.SetHistory(bank, PC, bytecode)
+		if cpu.haltBug {
+			cpu.haltBug = false
+			cpu.Reg.PC--
 		}
 
-		if handler != nil {
-			handler(cpu, operand1, operand2)
-		} else {
-			switch instruction {
-			case INS_LDH:
-				LDH(cpu, operand1, operand2)
-			case INS_AND:
-				cpu.AND(operand1, operand2)
-			case INS_XOR:
-				cpu.XOR(operand1, operand2)
-			case INS_CP:
-				cpu.CP(operand1, operand2)
-			case INS_OR:
-				cpu.OR(operand1, operand2)
-			case INS_ADD:
-				cpu.ADD(operand1, operand2)
-			case INS_SUB:
-				cpu.SUB(operand1, operand2)
-			case INS_ADC:
-				cpu.ADC(operand1, operand2)
-			case INS_SBC:
-				cpu.SBC(operand1, operand2)
-			default:
-				errMsg := fmt.Sprintf("eip: 0x%04x opcode: 0x%02x", cpu.Reg.PC, bytecode)
-				panic(errMsg)
+		if cpu.debug.on && cpu.debug.history.Flag() { // CB prefixed ops are logged as PREFIX CB
+			cpu.debug.history.SetHistory(bank, PC, byte(bytecode))
+		}
+
+		if bytecode == 0xcb { // prefix CB
+			cpu.Reg.PC++
+			cpu.timer(1)
+			bytecode = 0x100 | uint16(cpu.FetchMemory8(cpu.Reg.PC))
+			opcode = &opcodes[bytecode]
+			cycle = opcode.Cycle1 - 1
+			if cycle < 0 {
+				cycle = 0
 			}
 		}
+
+		opcode.Handler(cpu, opcode.Operand1, opcode.Operand2)
 	} else {
-		cycle = (max - cpu.Cycle.scanline)
-		if cycle > 16 { // make sound seemless
-			cycle = 16
-		}
-		if cycle == 0 {
-			cycle++
-		}
-		if !cpu.Reg.IME { // ref: https://rednex.github.io/rgbds/gbz80.7.html#HALT
-			IE, IF := cpu.RAM[IEIO], cpu.RAM[IFIO]
-			if pending := IE&IF > 0; pending {
+		// nothing happens until the next event
+		cycle = cpu.scheduler.until()
+		if cpu.RAM[IEIO]&cpu.RAM[IFIO]&0x1f != 0 { // wake up immediately
+			cycle = 0
+			if !cpu.Reg.IME { // ref: https://rednex.github.io/rgbds/gbz80.7.html#HALT
 				cpu.halt = false
 			}
 		}
-		if pending {
-			cpu.pend()
-		}
 	}
 
 	cpu.timer(cycle)
 	cpu.handleInterrupt()
 }
 
+// execScanline runs CPU until the current scanline is completed
 func (cpu *CPU) execScanline() (scx uint, scy uint, ok bool) {
-	// OAM mode2
-	cpu.setOAMRAMMode()
-	for cpu.Cycle.scanline <= 20*cpu.boost {
-		cpu.exec(20 * cpu.boost)
-	}
-
-	// LCD Driver mode3
-	cpu.Cycle.scanline -= 20 * cpu.boost
-	cpu.setLCDMode()
-	for cpu.Cycle.scanline <= 42*cpu.boost {
-		cpu.exec(42 * cpu.boost)
-	}
-
-	scrollX, scrollY := uint(cpu.GPU.Scroll[0]), uint(cpu.GPU.Scroll[1])
-
-	// HBlank mode0
-	cpu.Cycle.scanline -= 42 * cpu.boost
-	cpu.setHBlankMode()
-	for cpu.Cycle.scanline <= (cyclePerLine-(20+42))*cpu.boost {
-		cpu.exec((cyclePerLine - (20 + 42)) * cpu.boost)
+	for !cpu.lineEnd {
+		cpu.exec()
+		if cpu.stopped {
+			return 0, 0, false
+		}
 	}
-	cpu.Cycle.scanline -= (cyclePerLine - (20 + 42)) * cpu.boost
-
-	cpu.incrementLY()
-	return scrollX, scrollY, true
+	cpu.lineEnd = false
+	return cpu.lineScroll[0], cpu.lineScroll[1], true
 }
 
 // VBlank
 func (cpu *CPU) execVBlank() {
 	for {
-		for cpu.Cycle.scanline < cyclePerLine*cpu.boost {
-			cpu.exec(cyclePerLine * cpu.boost)
+		if _, _, ok := cpu.execScanline(); !ok {
+			break
 		}
-		cpu.incrementLY()
 		LY := cpu.FetchMemory8(LYIO)
 		if LY == 0 {
 			break
 		}
-		cpu.Cycle.scanline = 0
 	}
-	cpu.Cycle.scanline = 0
 }
 
 func (cpu *CPU) isBoost() bool {
 	return cpu.boost > 1
 }
+
+// normalCycles returns M-cycles in normal speed since power on
+func (cpu *CPU) normalCycles() uint64 {
+	return cpu.normal + (cpu.scheduler.now-cpu.normalAt)/uint64(cpu.boost)
+}
+
+// syncNormalCycles is called before speed switch
+func (cpu *CPU) syncNormalCycles() {
+	elapsed := (cpu.scheduler.now - cpu.normalAt) / uint64(cpu.boost)
+	cpu.normal += elapsed
+	cpu.normalAt += elapsed * uint64(cpu.boost)
+}
@@ -2,9 +2,13 @@ package gbc
 
 import (
 	"fmt"
+	"gbc/pkg/apu"
 	"gbc/pkg/debug"
 	"gbc/pkg/gpu"
 	"gbc/pkg/util"
+	"image"
+	"image/color"
+	"image/draw"
 	"image/jpeg"
 	"os"
 
@@ -20,6 +24,7 @@ type Debug struct {
 	pause   debug.Pause
 	Window  debug.Window
 	monitor debug.Monitor
+	mouse   [2]bool // left and right button were pressed
 }
 
 func (cpu *CPU) SetWindowSize(x, y int) {
@@ -183,3 +188,111 @@ func (cpu *CPU) debugPrintOAM(screen *ebiten.Image) {
 		ebitenutil.DebugPrintAt(screen, property, 750+(col*64)+42, 340)
 	}
 }
+
+// sound panel layout
+const (
+	soundPanelX, soundPanelY = 880, 5
+	soundRowY, soundRowH     = 25, 56
+	scopeH                   = 48
+)
+
+var scopeColors = [4]color.RGBA{
+	{0xff, 0x6b, 0x6b, 0xff},
+	{0xff, 0xd9, 0x3d, 0xff},
+	{0x6b, 0xcb, 0x77, 0xff},
+	{0x4d, 0x96, 0xff, 0xff},
+}
+
+func (cpu *CPU) debugPrintSound(screen *ebiten.Image) {
+	ebitenutil.DebugPrintAt(screen, "Sound (click: mute, right click: solo)", soundPanelX, soundPanelY)
+
+	for ch := 1; ch <= 4; ch++ {
+		y := soundRowY + (ch-1)*soundRowH
+		c := scopeColors[ch-1]
+		if cpu.Sound.Muted(ch) {
+			c = color.RGBA{0x80, 0x80, 0x80, 0xff}
+		}
+
+		scope := image.NewRGBA(image.Rect(0, 0, apu.ScopeLen, scopeH))
+		draw.Draw(scope, scope.Bounds(), &image.Uniform{color.RGBA{0x10, 0x10, 0x40, 0xff}}, image.Point{}, draw.Src)
+		prev := -1
+		for x, v := range cpu.Sound.Scope(ch) {
+			level := scopeH - 2 - int(v)*3
+			from, to := level, level
+			if prev >= 0 { // connect to the previous sample
+				if prev < from {
+					from = prev
+				} else if prev > to {
+					to = prev
+				}
+			}
+			for py := from; py <= to; py++ {
+				scope.Set(x, py, c)
+			}
+			prev = level
+		}
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Translate(float64(soundPanelX), float64(y))
+		screen.DrawImage(ebiten.NewImageFromImage(scope), op)
+
+		info := cpu.Sound.ChannelInfo(ch)
+		if cpu.Sound.Muted(ch) {
+			info = "[MUTE] " + info
+		}
+		ebitenutil.DebugPrintAt(screen, info, soundPanelX+apu.ScopeLen+8, y)
+	}
+
+	wave := "Wave RAM\n"
+	for i, b := range cpu.Sound.WaveRAM() {
+		wave += fmt.Sprintf("%02x", b)
+		if i%4 == 3 {
+			wave += " "
+		}
+	}
+	y := soundRowY + 4*soundRowH
+	ebitenutil.DebugPrintAt(screen, wave, soundPanelX, y)
+	ebitenutil.DebugPrintAt(screen, cpu.Sound.ControlInfo(), soundPanelX, y+32)
+}
+
+// soundPanelChannel returns channel(1-4) at (x, y) in sound panel, or 0
+func soundPanelChannel(x, y int) int {
+	if x < soundPanelX || y < soundRowY {
+		return 0
+	}
+	ch := (y-soundRowY)/soundRowH + 1
+	if ch > 4 {
+		return 0
+	}
+	return ch
+}
+
+// handleSoundPanel mutes clicked channel with left click and solos it with right click
+func (cpu *CPU) handleSoundPanel() {
+	left, right := ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft), ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight)
+	leftClick, rightClick := left && !cpu.debug.mouse[0], right && !cpu.debug.mouse[1]
+	cpu.debug.mouse = [2]bool{left, right}
+
+	ch := soundPanelChannel(ebiten.CursorPosition())
+	if ch == 0 {
+		return
+	}
+
+	switch {
+	case leftClick:
+		cpu.Sound.ToggleSoundChannel(ch)
+	case rightClick:
+		solo := !cpu.Sound.Muted(ch)
+		for i := 1; i <= 4; i++ {
+			if i != ch && !cpu.Sound.Muted(i) {
+				solo = false
+			}
+		}
+		// solo the channel, or unmute all if it is already solo
+		for i := 1; i <= 4; i++ {
+			mute := i != ch && !solo
+			if cpu.Sound.Muted(i) != mute {
+				cpu.Sound.ToggleSoundChannel(i)
+			}
+		}
+	}
+}
@@ -1,121 +1,189 @@
 package gbc
 
 import (
+	"time"
+
 	"gbc/pkg/gpu"
 	"gbc/pkg/util"
 
 	ebiten "github.com/hajimehoshi/ebiten/v2"
 )
 
+// frame - progress of the frame being emulated
+// Update runs it to the end at once, and split screen interleaves two of them.
+type frame struct {
+	count      int // frames since the last FPS update
+	fps        int
+	second     <-chan time.Time
+	skipRender bool
+
+	y                int // scanline being rendered
+	iterX            int
+	LCDC             byte
+	scrollX, scrollY uint
+	LCDC1            [144]bool
+	vblank           bool
+	done             bool
+}
+
 func (cpu *CPU) Update() error {
-	if frames == 0 {
+	if !cpu.beginFrame() {
+		return nil
+	}
+	for !cpu.frame.done {
+		cpu.step()
+	}
+	cpu.endFrame()
+	return nil
+}
+
+// beginFrame handles input and returns false if the frame isn't emulated (paused)
+func (cpu *CPU) beginFrame() bool {
+	f := &cpu.frame
+	if f.count == 0 {
 		setIcon()
 		cpu.debug.monitor.CPU.Reset()
 	}
-	if frames%3 == 0 {
+	if f.count%3 == 0 {
 		cpu.handleJoypad()
+		if cpu.gbs != nil {
+			cpu.handleGBSInput()
+		}
 	}
 
-	frames++
+	f.count++
 	cpu.debug.monitor.CPU.Reset()
+	if cpu.debug.on {
+		cpu.handleSoundPanel()
+	}
 
 	p, b := &cpu.debug.pause, &cpu.debug.Break
 	if p.Delay() {
 		p.DecrementDelay()
 	}
 	if p.On() || b.On() {
-		return nil
+		return false
 	}
 
-	skipRender = (cpu.Config.Display.FPS30) && (frames%2 == 1)
+	f.skipRender = (cpu.Config.Display.FPS30) && (f.count%2 == 1)
 
-	LCDC := cpu.FetchMemory8(LCDCIO)
-	scrollX, scrollY := uint(cpu.GPU.Scroll[0]), uint(cpu.GPU.Scroll[1])
-	scrollPixelX := scrollX % 8
+	f.LCDC = cpu.FetchMemory8(LCDCIO)
+	f.scrollX, f.scrollY = uint(cpu.GPU.Scroll[0]), uint(cpu.GPU.Scroll[1])
+	f.iterX = width
+	if f.scrollX%8 > 0 {
+		f.iterX += 8
+	}
+	// LY is 0 unless the previous frame ended in STOP mode. After waking up, rendering goes on from LY.
+	LY := int(cpu.FetchMemory8(LYIO))
+	f.y, f.LCDC1, f.vblank, f.done = LY, [144]bool{}, LY >= height, false
+	return true
+}
 
-	iterX, iterY := width, height
-	if scrollPixelX > 0 {
-		iterX += 8
+// step executes 1 instruction and renders the scanline which is completed
+func (cpu *CPU) step() {
+	f := &cpu.frame
+	cpu.exec()
+	if cpu.stopped { // LCD is blank in STOP mode
+		cpu.GPU.Blank()
+		f.done = true
+		return
+	}
+	if !cpu.lineEnd {
+		return
 	}
+	cpu.lineEnd = false
 
-	// render bg and run cpu
-	LCDC1 := [144]bool{}
-	for y := 0; y < iterY; y++ {
-		scx, scy, ok := cpu.execScanline()
-		if !ok {
-			break
-		}
-		scrollX, scrollY = scx, scy
-		scrollPixelX = scrollX % 8
+	if f.vblank {
+		f.done = cpu.FetchMemory8(LYIO) == 0
+		return
+	}
 
-		if y < height {
-			LCDC1[y] = util.Bit(cpu.FetchMemory8(LCDCIO), 1)
+	f.scrollX, f.scrollY = cpu.lineScroll[0], cpu.lineScroll[1]
+	cpu.renderLine(f.y)
+	f.y++
+	if f.y == height {
+		cpu.beginVBlank()
+	}
+}
+
+// renderLine renders background(or window) of scanline ${y}
+func (cpu *CPU) renderLine(y int) {
+	f := &cpu.frame
+	scrollX, scrollY := f.scrollX, f.scrollY
+	scrollPixelX := scrollX % 8
+	f.LCDC1[y] = util.Bit(cpu.FetchMemory8(LCDCIO), 1)
+
+	WY, WX := uint(cpu.FetchMemory8(WYIO)), uint(cpu.FetchMemory8(WXIO))-7
+	if f.skipRender {
+		return
+	}
+	for x := 0; x < f.iterX; x += 8 {
+		blockX, blockY := x/8, y/8
+
+		var tileX, tileY uint
+		var isWin bool
+		var entryX int
+
+		lineIdx := y % 8 // タイルの何行目を描画するか
+		entryY := gpu.EntryY{}
+		if util.Bit(f.LCDC, 5) && (WY <= uint(y)) && (WX <= uint(x)) {
+			tileX, tileY = ((uint(x)-WX)/8)%32, ((uint(y)-WY)/8)%32
+			isWin = true
+
+			entryX = blockX * 8
+			entryY.Block = blockY * 8
+			entryY.Offset = y % 8
+		} else {
+			tileX, tileY = (scrollX+uint(x))/8%32, (scrollY+uint(y))/8%32
+			isWin = false
+
+			entryX = blockX*8 - int(scrollPixelX)
+			entryY.Block = blockY * 8
+			entryY.Offset = y % 8
+			lineIdx = (int(scrollY) + y) % 8
 		}
 
-		// render background(or window)
-		WY, WX := uint(cpu.FetchMemory8(WYIO)), uint(cpu.FetchMemory8(WXIO))-7
-		if !skipRender {
-			for x := 0; x < iterX; x += 8 {
-				blockX, blockY := x/8, y/8
-
-				var tileX, tileY uint
-				var isWin bool
-				var entryX int
-
-				lineIdx := y % 8 // タイルの何行目を描画するか
-				entryY := gpu.EntryY{}
-				if util.Bit(LCDC, 5) && (WY <= uint(y)) && (WX <= uint(x)) {
-					tileX, tileY = ((uint(x)-WX)/8)%32, ((uint(y)-WY)/8)%32
-					isWin = true
-
-					entryX = blockX * 8
-					entryY.Block = blockY * 8
-					entryY.Offset = y % 8
-				} else {
-					tileX, tileY = (scrollX+uint(x))/8%32, (scrollY+uint(y))/8%32
-					isWin = false
-
-					entryX = blockX*8 - int(scrollPixelX)
-					entryY.Block = blockY * 8
-					entryY.Offset = y % 8
-					lineIdx = (int(scrollY) + y) % 8
-				}
-
-				if util.Bit(LCDC, 7) {
-					cpu.GPU.SetBGLine(entryX, entryY, tileX, tileY, isWin, cpu.Cartridge.IsCGB, lineIdx)
-				}
-			}
+		if util.Bit(f.LCDC, 7) {
+			cpu.GPU.SetBGLine(entryX, entryY, tileX, tileY, isWin, cpu.Cartridge.IsCGB, lineIdx)
 		}
 	}
+}
+
+// beginVBlank renders sprites after all scanlines
+func (cpu *CPU) beginVBlank() {
+	f := &cpu.frame
+	f.vblank = true
 
 	// save bgmap and tiledata on debug mode
 	if cpu.debug.on {
-		if !skipRender {
+		if !f.skipRender {
 			bg := cpu.GPU.Display(false)
 			cpu.GPU.Debug.SetBGMap(bg)
 		}
-		if frames%4 == 0 {
+		if f.count%4 == 0 {
 			go func() {
 				cpu.GPU.UpdateTileData(cpu.Cartridge.IsCGB)
 			}()
 		}
 	}
 
-	if !skipRender {
-		cpu.renderSprite(&LCDC1)   // render sprite
+	if !f.skipRender {
+		cpu.renderSprite(&f.LCDC1) // render sprite
 		cpu.GPU.SetBGPriorPixels() // render bg has higher priority
 	}
+}
 
-	cpu.execVBlank()
-	if cpu.debug.on {
-		select {
-		case <-second:
-			fps = frames
-			frames = 0
-		default:
+func (cpu *CPU) endFrame() {
+	f := &cpu.frame
+	select {
+	case <-f.second:
+		if cpu.debug.on {
+			f.fps = f.count
+			f.count = 0
 		}
+		cpu.autosave()
+	default:
 	}
-	return nil
 }
 
 func (cpu *CPU) Draw(screen *ebiten.Image) {
@@ -0,0 +1,165 @@
+package gbc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GBS - Game Boy Sound System file
+// Music data is mapped as MBC1 ROM and a small driver calls play routine from timer or VBlank interrupt.
+type GBS struct {
+	Count, First          byte // number of songs, first song(1-)
+	Load, Init, Play, SP  uint16
+	TMA, TAC              byte
+	Title, Author, Credit string
+
+	song  int  // current song(0-)
+	left  bool // left key was pressed
+	right bool // right key was pressed
+}
+
+const (
+	gbsHeaderSize = 0x70
+	gbsDriver     = 0x0200 // driver loop address
+)
+
+// EI; HALT; NOP; CALL play; JR driver
+var gbsDriverCode = [8]byte{0xfb, 0x76, 0x00, 0xcd, 0x00, 0x00, 0x18, 0xf8}
+
+func parseGBS(data []byte) (*GBS, error) {
+	if len(data) < gbsHeaderSize || string(data[0:3]) != "GBS" {
+		return nil, errors.New("invalid GBS header")
+	}
+	if data[3] != 1 {
+		return nil, fmt.Errorf("unsupported GBS version: %d", data[3])
+	}
+
+	str := func(b []byte) string {
+		return strings.TrimRight(string(b), "\x00")
+	}
+	g := &GBS{
+		Count: data[0x04], First: data[0x05],
+		Load: binary.LittleEndian.Uint16(data[0x06:]),
+		Init: binary.LittleEndian.Uint16(data[0x08:]),
+		Play: binary.LittleEndian.Uint16(data[0x0a:]),
+		SP:   binary.LittleEndian.Uint16(data[0x0c:]),
+		TMA:  data[0x0e], TAC: data[0x0f],
+		Title: str(data[0x10:0x30]), Author: str(data[0x30:0x50]), Credit: str(data[0x50:0x70]),
+	}
+	if g.Load < 0x400 {
+		return nil, fmt.Errorf("GBS load address is too low: 0x%04x", g.Load)
+	}
+	if g.Count == 0 {
+		return nil, errors.New("GBS has no songs")
+	}
+	if g.First == 0 || g.First > g.Count {
+		g.First = 1
+	}
+	return g, nil
+}
+
+// rom builds ROM image. 0x0000-0x03ff is used by RST vectors, interrupt vectors and driver.
+func (g *GBS) rom(data []byte) ([]byte, error) {
+	size := int(g.Load) + len(data) - gbsHeaderSize
+	romSize := 0
+	for (0x8000 << romSize) < size {
+		romSize++
+	}
+	if romSize > 6 {
+		return nil, errors.New("GBS data is too large")
+	}
+
+	rom := make([]byte, 0x8000<<romSize)
+	copy(rom[g.Load:], data[gbsHeaderSize:])
+
+	for n := uint16(0); n < 0x40; n += 8 { // RST n => JP load+n
+		addr := g.Load + n
+		rom[n], rom[n+1], rom[n+2] = 0xc3, byte(addr), byte(addr>>8)
+	}
+	for n := 0x40; n <= 0x60; n += 8 { // interrupt returns to driver
+		rom[n] = 0xd9 // RETI
+	}
+	copy(rom[gbsDriver:], gbsDriverCode[:])
+	rom[gbsDriver+4], rom[gbsDriver+5] = byte(g.Play), byte(g.Play>>8)
+
+	copy(rom[0x0134:0x0143], g.Title)
+	rom[0x0147], rom[0x0148] = 0x01, byte(romSize) // MBC1
+	return rom, nil
+}
+
+// LoadGBS maps GBS file as cartridge
+func (cpu *CPU) LoadGBS(data []byte) error {
+	g, err := parseGBS(data)
+	if err != nil {
+		return err
+	}
+	rom, err := g.rom(data)
+	if err != nil {
+		return err
+	}
+
+	cpu.Cartridge.ParseCartridge(rom)
+	cpu.TransferROM(rom)
+	cpu.gbs = g
+	return nil
+}
+
+// playTrack calls init routine of song ${song}(0-)
+// Double speed mode (TAC bit7) isn't supported.
+func (cpu *CPU) playTrack(song int) {
+	g := cpu.gbs
+	g.song = song
+
+	for i := range cpu.RAMBank.bank[0] {
+		cpu.RAMBank.bank[0][i] = 0
+	}
+	for i := 0xc000; i < 0xe000; i++ {
+		cpu.RAM[i] = 0
+	}
+	for i := 0xff80; i < 0xffff; i++ {
+		cpu.RAM[i] = 0
+	}
+	cpu.switchROMBank(1)
+
+	cpu.SetMemory8(0xff26, 0x00) // reset APU
+	cpu.SetMemory8(0xff26, 0x80)
+	cpu.SetMemory8(0xff25, 0xff)
+	cpu.SetMemory8(0xff24, 0x77)
+
+	cpu.SetMemory8(TMAIO, g.TMA)
+	cpu.SetMemory8(TIMAIO, g.TMA)
+	cpu.SetMemory8(TACIO, g.TAC&0x07)
+	cpu.SetMemory8(IFIO, 0)
+	if g.TAC&0x04 != 0 {
+		cpu.SetMemory8(IEIO, 0x04) // timer
+	} else {
+		cpu.SetMemory8(IEIO, 0x01) // VBlank
+	}
+
+	cpu.halt, cpu.haltBug, cpu.stopped, cpu.locked = false, false, false, false
+	cpu.Reg.IME = false
+	cpu.Reg.SP = g.SP
+	cpu.Reg.PC = gbsDriver
+	cpu.pushPC() // init returns to driver
+	cpu.Reg.PC = g.Init
+	cpu.Reg.R[A] = byte(song)
+
+	fmt.Fprintf(os.Stderr, "GBS: %s - %s (%d/%d)\n", g.Title, g.Author, song+1, g.Count)
+}
+
+// handleGBSInput switches songs by right(next) and left(previous)
+func (cpu *CPU) handleGBSInput() {
+	g := cpu.gbs
+	right, left := cpu.joypad.Direction[0], cpu.joypad.Direction[1]
+	count := int(g.Count)
+	switch {
+	case right && !g.right:
+		cpu.playTrack((g.song + 1) % count)
+	case left && !g.left:
+		cpu.playTrack((g.song + count - 1) % count)
+	}
+	g.right, g.left = right, left
+}
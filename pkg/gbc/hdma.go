@@ -0,0 +1,83 @@
+package gbc
+
+// HDMA - VRAM DMA ゲームボーイカラーのみ
+type HDMA struct {
+	length int  // remaining blocks (1 block = 0x10 bytes)
+	hblank bool // HBlank DMA is in progress
+	stall  int  // CPU cycles stolen by DMA
+}
+
+// M-cycles to transfer 1 block in normal speed mode
+const hdmaBlockCycle = 8
+
+func (cpu *CPU) readHDMA5() byte {
+	switch {
+	case cpu.HDMA.length == 0: // complete
+		return 0xff
+	case cpu.HDMA.hblank:
+		return byte(cpu.HDMA.length - 1)
+	default: // HBlank DMA is canceled
+		return 0x80 | byte(cpu.HDMA.length-1)
+	}
+}
+
+func (cpu *CPU) writeHDMA5(value byte) {
+	if cpu.HDMA.hblank && value&0x80 == 0 { // cancel HBlank DMA
+		cpu.HDMA.hblank = false
+		return
+	}
+
+	cpu.HDMA.length = int(value&0x7f) + 1
+	if value&0x80 == 0 { // general purpose DMA halts CPU until all blocks are transferred
+		for cpu.HDMA.length > 0 {
+			cpu.transferHDMABlock()
+		}
+		return
+	}
+
+	cpu.HDMA.hblank = true
+	if cpu.mode == HBlankMode { // 1st block is transferred immediately in HBlank
+		cpu.transferHDMABlock()
+	}
+}
+
+// hblankDMA transfers 1 block at the start of each HBlank
+func (cpu *CPU) hblankDMA() {
+	if cpu.HDMA.hblank {
+		cpu.transferHDMABlock()
+	}
+}
+
+func (cpu *CPU) transferHDMABlock() {
+	from := (uint16(cpu.RAM[HDMA1IO])<<8 | uint16(cpu.RAM[HDMA2IO])) & 0xfff0
+	to := (uint16(cpu.RAM[HDMA3IO])<<8 | uint16(cpu.RAM[HDMA4IO])) & 0x1ff0
+
+	vram := &cpu.GPU.VRAM.Bank[cpu.GPU.VRAM.Ptr]
+	for i := uint16(0); i < 0x10; i++ {
+		vram[to+i] = cpu.fetchHDMASource(from + i)
+	}
+	from, to = from+0x10, to+0x10
+
+	cpu.RAM[HDMA1IO], cpu.RAM[HDMA2IO] = byte(from>>8), byte(from)
+	cpu.RAM[HDMA3IO], cpu.RAM[HDMA4IO] = byte(to>>8)&0x1f, byte(to)
+
+	cpu.HDMA.length--
+	if to >= 0x2000 { // transfer stops when destination overflows 0x9fff
+		cpu.HDMA.length = 0
+	}
+	if cpu.HDMA.length == 0 {
+		cpu.HDMA.hblank = false
+	}
+	cpu.HDMA.stall += hdmaBlockCycle * cpu.boost // double speed mode takes twice as many CPU cycles
+}
+
+// VRAM can't be source. 0xe000-0xffff reads 0xa000-0xbfff.
+func (cpu *CPU) fetchHDMASource(addr uint16) byte {
+	switch {
+	case addr >= 0x8000 && addr < 0xa000:
+		return 0xff
+	case addr >= 0xe000:
+		addr -= 0x4000
+	}
+	return cpu.FetchMemory8(addr)
+}
@@ -70,6 +70,10 @@ func (cpu *CPU) setJoypadFlag(b bool) {
 
 func (cpu *CPU) triggerInterrupt() {
 	cpu.Reg.IME, cpu.halt = false, false
+	if cpu.haltBug { // e.g. EI, HALT: interrupt returns to HALT
+		cpu.haltBug = false
+		cpu.Reg.PC--
+	}
 	cpu.timer(5) // https://gbdev.gg8.se/wiki/articles/Interrupts#InterruptServiceRoutine
 	cpu.pushPC()
 }
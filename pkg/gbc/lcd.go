@@ -7,16 +7,7 @@ func (cpu *CPU) setHBlankMode() {
 	stat := cpu.FetchMemory8(LCDSTATIO) & 0b1111_1100
 	cpu.SetMemory8(LCDSTATIO, stat)
 
-	if cpu.GPU.HBlankDMALength > 0 {
-		cpu.doVRAMDMATransfer(0x10)
-		if cpu.GPU.HBlankDMALength == 1 {
-			cpu.GPU.HBlankDMALength--
-			cpu.RAM[HDMA5IO] = 0xff
-		} else {
-			cpu.GPU.HBlankDMALength--
-			cpu.RAM[HDMA5IO] = byte(cpu.GPU.HBlankDMALength)
-		}
-	}
+	cpu.hblankDMA()
 
 	if util.Bit(stat, 3) {
 		cpu.setLCDSTATFlag(true)
@@ -71,3 +62,25 @@ func (cpu *CPU) checkLYC(LY uint8) {
 	stat := cpu.FetchMemory8(LCDSTATIO) & 0b11111011 // clear lyc flag
 	cpu.SetMemory8(LCDSTATIO, stat)
 }
+
+// ppuEvent switches LCD mode and schedules the next mode change
+func (cpu *CPU) ppuEvent() {
+	switch cpu.mode {
+	case OAMRAMMode:
+		cpu.setLCDMode()
+		cpu.scheduler.schedule(eventPPU, 42*cpu.boost)
+	case LCDMode:
+		cpu.lineScroll = [2]uint{uint(cpu.GPU.Scroll[0]), uint(cpu.GPU.Scroll[1])}
+		cpu.setHBlankMode()
+		cpu.scheduler.schedule(eventPPU, (cyclePerLine-(20+42))*cpu.boost)
+	default: // end of scanline
+		cpu.incrementLY()
+		cpu.lineEnd = true
+		if cpu.RAM[LYIO] >= 144 {
+			cpu.scheduler.schedule(eventPPU, cyclePerLine*cpu.boost)
+			return
+		}
+		cpu.setOAMRAMMode()
+		cpu.scheduler.schedule(eventPPU, 20*cpu.boost)
+	}
+}
@@ -0,0 +1,71 @@
+package gbc
+
+// MemoryMap - page table for FetchMemory8 and SetMemory8. One page is 256 bytes.
+// nil page falls through to handlers (MBC, RTC, IO, OAM DMA).
+type MemoryMap struct {
+	read, write [0x100][]byte
+}
+
+func mapPages(table *[0x100][]byte, start uint16, mem []byte) {
+	for i := 0; i < len(mem)/0x100; i++ {
+		table[int(start>>8)+i] = mem[i*0x100 : (i+1)*0x100]
+	}
+}
+
+func unmapPages(table *[0x100][]byte, start, size uint16) {
+	for i := 0; i < int(size)/0x100; i++ {
+		table[int(start>>8)+i] = nil
+	}
+}
+
+func (cpu *CPU) initMemoryMap() {
+	m := &cpu.memory
+	mapPages(&m.read, 0x0000, cpu.RAM[0x0000:0x4000]) // rom bank0
+	cpu.mapROMBank()
+	cpu.mapVRAMBank()
+	cpu.mapRAMBank()
+	mapPages(&m.read, 0xc000, cpu.RAM[0xc000:0xd000]) // wram bank0
+	mapPages(&m.write, 0xc000, cpu.RAM[0xc000:0xd000])
+	cpu.mapWRAMBank()
+	mapPages(&m.read, 0xe000, cpu.RAM[0xe000:0xff00]) // echo ram, OAM
+	mapPages(&m.write, 0xe000, cpu.RAM[0xe000:0xff00])
+}
+
+// 0x4000-0x7fff: writes go to MBC
+func (cpu *CPU) mapROMBank() {
+	mapPages(&cpu.memory.read, 0x4000, cpu.ROMBank.bank[cpu.ROMBank.ptr][:])
+}
+
+func (cpu *CPU) mapVRAMBank() {
+	vram := cpu.GPU.VRAM.Bank[cpu.GPU.VRAM.Ptr][:]
+	mapPages(&cpu.memory.read, 0x8000, vram)
+	mapPages(&cpu.memory.write, 0x8000, vram)
+}
+
+// 0xa000-0xbfff: RTC register is handled in FetchMemory8 and SetMemory8
+// Writes to clean SRAM also go to SetMemory8 to mark it dirty.
+func (cpu *CPU) mapRAMBank() {
+	m := &cpu.memory
+	if cpu.RTC.Mapped != 0 {
+		unmapPages(&m.read, 0xa000, 0x2000)
+		unmapPages(&m.write, 0xa000, 0x2000)
+		return
+	}
+	ram := cpu.RAMBank.bank[cpu.RAMBank.ptr][:]
+	mapPages(&m.read, 0xa000, ram)
+	if cpu.sram.dirty {
+		mapPages(&m.write, 0xa000, ram)
+	} else {
+		unmapPages(&m.write, 0xa000, 0x2000)
+	}
+}
+
+// 0xd000-0xdfff: bank1 is in RAM, bank2-7 are in WRAMBank
+func (cpu *CPU) mapWRAMBank() {
+	wram := cpu.RAM[0xd000:0xe000]
+	if cpu.WRAMBank.ptr > 1 {
+		wram = cpu.WRAMBank.bank[cpu.WRAMBank.ptr][:]
+	}
+	mapPages(&cpu.memory.read, 0xd000, wram)
+	mapPages(&cpu.memory.write, 0xd000, wram)
+}
@@ -1,7 +1,6 @@
 package gbc
 
 import (
-	"fmt"
 	"gbc/pkg/util"
 )
 
@@ -135,25 +134,22 @@ func ldm16r(cpu *CPU, r16, r8 int) {
 	cpu.Reg.PC++
 }
 
-// LDH Load High Byte
-func LDH(cpu *CPU, operand1, operand2 int) {
-	if operand1 == OP_A && operand2 == OP_a8_PAREN { // LD A,($FF00+a8)
-		addr := 0xff00 + uint16(cpu.FetchMemory8(cpu.Reg.PC+1))
-		cpu.timer(1)
-		value := cpu.fetchIO(addr)
+// LD (FF00+u8),A
+func op0xe0(cpu *CPU, _, _ int) {
+	addr := 0xff00 + uint16(cpu.FetchMemory8(cpu.Reg.PC+1))
+	cpu.timer(1)
+	cpu.setIO(addr, cpu.Reg.R[A])
+	cpu.Reg.PC += 2
+	cpu.timer(2)
+}
 
-		cpu.Reg.R[A] = value
-		cpu.Reg.PC += 2
-		cpu.timer(2)
-	} else if operand1 == OP_a8_PAREN && operand2 == OP_A { // LD ($FF00+a8),A
-		addr := 0xff00 + uint16(cpu.FetchMemory8(cpu.Reg.PC+1))
-		cpu.timer(1)
-		cpu.setIO(addr, cpu.Reg.R[A])
-		cpu.Reg.PC += 2
-		cpu.timer(2)
-	} else {
-		panic(fmt.Errorf("error: LDH %d %d", operand1, operand2))
-	}
+// LD A,(FF00+u8)
+func op0xf0(cpu *CPU, _, _ int) {
+	addr := 0xff00 + uint16(cpu.FetchMemory8(cpu.Reg.PC+1))
+	cpu.timer(1)
+	cpu.Reg.R[A] = cpu.fetchIO(addr)
+	cpu.Reg.PC += 2
+	cpu.timer(2)
 }
 
 // No operation
@@ -250,35 +246,27 @@ func jrncc(cpu *CPU, cc, _ int) {
 	}
 }
 
-var pending bool
-
 func halt(cpu *CPU, _, _ int) {
 	cpu.Reg.PC++
-	cpu.halt = true
 
 	// ref: https://rednex.github.io/rgbds/gbz80.7.html#HALT
-	if !cpu.Reg.IME {
-		IE, IF := cpu.RAM[IEIO], cpu.RAM[IFIO]
-		pending = IE&IF != 0
+	if !cpu.Reg.IME && cpu.RAM[IEIO]&cpu.RAM[IFIO]&0x1f != 0 {
+		// HALT bug: CPU doesn't halt and fails to increment PC on the next fetch
+		cpu.haltBug = true
+		return
 	}
-}
-
-func (cpu *CPU) pend() {
-	// Some pending
-	cpu.halt = false
-	PC := cpu.Reg.PC
-	cpu.exec(1)
-	cpu.Reg.PC = PC
-
-	// IME turns on due to EI delay.
-	cpu.halt = cpu.Reg.IME
+	cpu.halt = true
 }
 
 // stop CPU
 func stop(cpu *CPU, _, _ int) {
 	cpu.Reg.PC += 2
+	cpu.setIO(DIVIO, 0) // STOP resets DIV
+
 	KEY1 := cpu.FetchMemory8(KEY1IO)
-	if util.Bit(KEY1, 0) {
+	if util.Bit(KEY1, 0) { // speed switch
+		cpu.syncRTC()
+		cpu.syncNormalCycles()
 		if util.Bit(KEY1, 7) {
 			KEY1 = 0x00
 			cpu.boost = 1
@@ -287,40 +275,38 @@ func stop(cpu *CPU, _, _ int) {
 			cpu.boost = 2
 		}
 		cpu.SetMemory8(KEY1IO, KEY1)
+		return
 	}
+
+	// low-power mode until one of joypad lines goes low
+	cpu.stopped = true
 }
 
 // XOR xor
-func xor8(cpu *CPU, _, r8 int) {
-	value := cpu.Reg.R[A] ^ cpu.Reg.R[r8]
+func (cpu *CPU) xorA(value byte) {
+	value ^= cpu.Reg.R[A]
 	cpu.Reg.R[A] = value
 	cpu.setF(flagZ, value == 0)
 	cpu.setF(flagN, false)
 	cpu.setF(flagH, false)
 	cpu.setF(flagC, false)
-	cpu.Reg.PC++
 }
 
-func (cpu *CPU) XOR(operand1, operand2 int) {
-	var value byte
-	switch operand1 {
-	case OP_HL_PAREN:
-		value = cpu.Reg.R[A] ^ cpu.FetchMemory8(cpu.Reg.HL())
-	case OP_d8:
-		value = cpu.Reg.R[A] ^ cpu.FetchMemory8(cpu.Reg.PC+1)
-		cpu.Reg.PC++
-	default:
-		panic(fmt.Errorf("error: XOR %d %d", operand1, operand2))
-	}
+func xor8(cpu *CPU, _, r8 int) {
+	cpu.xorA(cpu.Reg.R[r8])
+	cpu.Reg.PC++
+}
 
-	cpu.Reg.R[A] = value
-	cpu.setF(flagZ, value == 0)
-	cpu.setF(flagN, false)
-	cpu.setF(flagH, false)
-	cpu.setF(flagC, false)
+func xor8m(cpu *CPU, _, _ int) {
+	cpu.xorA(cpu.FetchMemory8(cpu.Reg.HL()))
 	cpu.Reg.PC++
 }
 
+func xor8i(cpu *CPU, _, _ int) {
+	cpu.xorA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
+}
+
 // jp u16
 func jp(cpu *CPU, _, _ int) {
 	cpu.Reg.PC = cpu.a16FetchJP()
@@ -419,6 +405,7 @@ func di(cpu *CPU, _, _ int) {
 	cpu.Reg.PC++
 	if cpu.IMESwitch.Working && cpu.IMESwitch.Value {
 		cpu.IMESwitch.Working = false // https://gbdev.gg8.se/wiki/articles/Interrupts 『The effect of EI is delayed by one instruction. This means that EI followed immediately by DI does not allow interrupts between the EI and the DI.』
+		cpu.scheduler.cancel(eventIMESwitch)
 	}
 }
 
@@ -439,114 +426,113 @@ func ei(cpu *CPU, _, _ int) {
 			Value:   true,
 			Working: true,
 		}
+		cpu.scheduler.schedule(eventIMESwitch, int(cpu.IMESwitch.Count))
 	}
 	cpu.Reg.PC++
 }
 
 // CP Compare
-func cp(cpu *CPU, _, r8 int) {
-	value := cpu.Reg.R[A] - cpu.Reg.R[r8]
-	carryBits := cpu.Reg.R[A] ^ cpu.Reg.R[r8] ^ value
-	cpu.setCSub(cpu.Reg.R[A], cpu.Reg.R[r8])
+func (cpu *CPU) cpA(data byte) {
+	value := cpu.Reg.R[A] - data
+	carryBits := cpu.Reg.R[A] ^ data ^ value
+	cpu.setCSub(cpu.Reg.R[A], data)
 
 	cpu.setF(flagZ, value == 0)
 	cpu.setF(flagN, true)
 	cpu.setF(flagH, util.Bit(carryBits, 4))
+}
+
+func cp(cpu *CPU, _, r8 int) {
+	cpu.cpA(cpu.Reg.R[r8])
 	cpu.Reg.PC++
 }
 
-func (cpu *CPU) CP(operand1, operand2 int) {
-	var value, carryBits byte
-	switch operand1 {
-	case OP_d8:
-		value = cpu.Reg.R[A] - cpu.d8Fetch()
-		carryBits = cpu.Reg.R[A] ^ cpu.d8Fetch() ^ value
-		cpu.setCSub(cpu.Reg.R[A], cpu.d8Fetch())
-		cpu.Reg.PC++
-	case OP_HL_PAREN:
-		value = cpu.Reg.R[A] - cpu.FetchMemory8(cpu.Reg.HL())
-		carryBits = cpu.Reg.R[A] ^ cpu.FetchMemory8(cpu.Reg.HL()) ^ value
-		cpu.setCSub(cpu.Reg.R[A], cpu.FetchMemory8(cpu.Reg.HL()))
-	}
-	cpu.setF(flagZ, value == 0)
-	cpu.setF(flagN, true)
-	cpu.setF(flagH, util.Bit(carryBits, 4))
+func cpm(cpu *CPU, _, _ int) {
+	cpu.cpA(cpu.FetchMemory8(cpu.Reg.HL()))
 	cpu.Reg.PC++
 }
 
-// AND And instruction
+func cpi(cpu *CPU, _, _ int) {
+	cpu.cpA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
+}
 
-func and8(cpu *CPU, _, r8 int) {
-	value := cpu.Reg.R[A] & cpu.Reg.R[r8]
+// AND And instruction
+func (cpu *CPU) andA(value byte) {
+	value &= cpu.Reg.R[A]
 	cpu.Reg.R[A] = value
 	cpu.setF(flagZ, value == 0)
 	cpu.setF(flagN, false)
 	cpu.setF(flagH, true)
 	cpu.setF(flagC, false)
-	cpu.Reg.PC++
 }
 
-func (cpu *CPU) AND(operand1, operand2 int) {
-	var value byte
-	switch operand1 {
-	case OP_HL_PAREN:
-		value = cpu.Reg.R[A] & cpu.FetchMemory8(cpu.Reg.HL())
-	case OP_d8:
-		value = cpu.Reg.R[A] & cpu.d8Fetch()
-		cpu.Reg.PC++
-	}
+func and8(cpu *CPU, _, r8 int) {
+	cpu.andA(cpu.Reg.R[r8])
+	cpu.Reg.PC++
+}
 
-	cpu.Reg.R[A] = value
-	cpu.setF(flagZ, value == 0)
-	cpu.setF(flagN, false)
-	cpu.setF(flagH, true)
-	cpu.setF(flagC, false)
+func and8m(cpu *CPU, _, _ int) {
+	cpu.andA(cpu.FetchMemory8(cpu.Reg.HL()))
 	cpu.Reg.PC++
 }
 
+func and8i(cpu *CPU, _, _ int) {
+	cpu.andA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
+}
+
 // OR or
-func orR8(cpu *CPU, _, r8 int) {
-	value := cpu.Reg.R[A] | cpu.Reg.R[r8]
+func (cpu *CPU) orA(value byte) {
+	value |= cpu.Reg.R[A]
 	cpu.Reg.R[A] = value
 	cpu.setF(flagZ, value == 0)
-
 	cpu.setF(flagN, false)
 	cpu.setF(flagH, false)
 	cpu.setF(flagC, false)
-	cpu.Reg.PC++
 }
 
-func (cpu *CPU) OR(operand1, operand2 int) {
-	switch operand1 {
-	case OP_d8:
-		value := cpu.Reg.R[A] | cpu.FetchMemory8(cpu.Reg.PC+1)
-		cpu.Reg.R[A] = value
-		cpu.setF(flagZ, value == 0)
-		cpu.Reg.PC++
-	case OP_HL_PAREN:
-		value := cpu.Reg.R[A] | cpu.FetchMemory8(cpu.Reg.HL())
-		cpu.Reg.R[A] = value
-		cpu.setF(flagZ, value == 0)
-	}
+func orR8(cpu *CPU, _, r8 int) {
+	cpu.orA(cpu.Reg.R[r8])
+	cpu.Reg.PC++
+}
 
-	cpu.setF(flagN, false)
-	cpu.setF(flagH, false)
-	cpu.setF(flagC, false)
+func or8m(cpu *CPU, _, _ int) {
+	cpu.orA(cpu.FetchMemory8(cpu.Reg.HL()))
 	cpu.Reg.PC++
 }
 
+func or8i(cpu *CPU, _, _ int) {
+	cpu.orA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
+}
+
 // ADD Addition
-func add8(cpu *CPU, _, r8 int) {
-	value := uint16(cpu.Reg.R[A]) + uint16(cpu.Reg.R[r8])
-	carryBits := uint16(cpu.Reg.R[A]) ^ uint16(cpu.Reg.R[r8]) ^ value
+func (cpu *CPU) addA(data byte) {
+	value := uint16(cpu.Reg.R[A]) + uint16(data)
+	carryBits := uint16(cpu.Reg.R[A]) ^ uint16(data) ^ value
 	cpu.Reg.R[A] = byte(value)
 	cpu.setF(flagZ, byte(value) == 0)
 	cpu.setF(flagN, false)
 	cpu.setF(flagH, util.Bit(carryBits, 4))
 	cpu.setF(flagC, util.Bit(carryBits, 8))
+}
+
+func add8(cpu *CPU, _, r8 int) {
+	cpu.addA(cpu.Reg.R[r8])
+	cpu.Reg.PC++
+}
+
+func add8m(cpu *CPU, _, _ int) {
+	cpu.addA(cpu.FetchMemory8(cpu.Reg.HL()))
 	cpu.Reg.PC++
 }
 
+func add8i(cpu *CPU, _, _ int) {
+	cpu.addA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
+}
+
 // add hl,r16
 func addHL(cpu *CPU, _, r16 int) {
 	value := uint32(cpu.Reg.HL()) + uint32(cpu.Reg.R16(r16))
@@ -558,43 +544,17 @@ func addHL(cpu *CPU, _, r16 int) {
 	cpu.Reg.PC++
 }
 
-func (cpu *CPU) ADD(operand1, operand2 int) {
-	switch operand1 {
-	case OP_A:
-		switch operand2 {
-		case OP_d8:
-			value := uint16(cpu.Reg.R[A]) + uint16(cpu.d8Fetch())
-			carryBits := uint16(cpu.Reg.R[A]) ^ uint16(cpu.d8Fetch()) ^ value
-			cpu.Reg.R[A] = byte(value)
-			cpu.setF(flagZ, byte(value) == 0)
-			cpu.setF(flagN, false)
-			cpu.setF(flagH, util.Bit(carryBits, 4))
-			cpu.setF(flagC, util.Bit(carryBits, 8))
-			cpu.Reg.PC += 2
-		case OP_HL_PAREN:
-			value := uint16(cpu.Reg.R[A]) + uint16(cpu.FetchMemory8(cpu.Reg.HL()))
-			carryBits := uint16(cpu.Reg.R[A]) ^ uint16(cpu.FetchMemory8(cpu.Reg.HL())) ^ value
-			cpu.Reg.R[A] = byte(value)
-			cpu.setF(flagZ, byte(value) == 0)
-			cpu.setF(flagN, false)
-			cpu.setF(flagH, util.Bit(carryBits, 4))
-			cpu.setF(flagC, util.Bit(carryBits, 8))
-			cpu.Reg.PC++
-		}
-	case OP_SP:
-		switch operand2 {
-		case OP_r8:
-			delta := int8(cpu.FetchMemory8(cpu.Reg.PC + 1))
-			value := int32(cpu.Reg.SP) + int32(delta)
-			carryBits := uint32(cpu.Reg.SP) ^ uint32(delta) ^ uint32(value)
-			cpu.Reg.SP = uint16(value)
-			cpu.setF(flagZ, false)
-			cpu.setF(flagN, false)
-			cpu.setF(flagH, util.Bit(carryBits, 4))
-			cpu.setF(flagC, util.Bit(carryBits, 8))
-			cpu.Reg.PC += 2
-		}
-	}
+// ADD SP,i8
+func op0xe8(cpu *CPU, _, _ int) {
+	delta := int8(cpu.FetchMemory8(cpu.Reg.PC + 1))
+	value := int32(cpu.Reg.SP) + int32(delta)
+	carryBits := uint32(cpu.Reg.SP) ^ uint32(delta) ^ uint32(value)
+	cpu.Reg.SP = uint16(value)
+	cpu.setF(flagZ, false)
+	cpu.setF(flagN, false)
+	cpu.setF(flagH, util.Bit(carryBits, 4))
+	cpu.setF(flagC, util.Bit(carryBits, 8))
+	cpu.Reg.PC += 2
 }
 
 // complement A Register
@@ -605,19 +565,6 @@ func cpl(cpu *CPU, _, _ int) {
 	cpu.Reg.PC++
 }
 
-// extend instruction
-func prefixCB(cpu *CPU, _, _ int) {
-	cpu.Reg.PC++
-	cpu.timer(1)
-	op := prefixCBs[cpu.FetchMemory8(cpu.Reg.PC)]
-	_, op1, op2, cycle, handler := op.Ins, op.Operand1, op.Operand2, op.Cycle1, op.Handler
-	handler(cpu, op1, op2)
-
-	if cycle > 1 {
-		cpu.timer(cycle - 1)
-	}
-}
-
 // RLC Rotate n left carry => bit0
 func rlc(cpu *CPU, r8, _ int) {
 	value := cpu.Reg.R[r8]
@@ -992,38 +939,29 @@ func pop(cpu *CPU, r0, r1 int) {
 }
 
 // SUB subtract
-func sub8(cpu *CPU, _, r8 int) {
-	value := cpu.Reg.R[A] - cpu.Reg.R[r8]
-	carryBits := cpu.Reg.R[A] ^ cpu.Reg.R[r8] ^ value
-	cpu.setCSub(cpu.Reg.R[A], cpu.Reg.R[r8])
+func (cpu *CPU) subA(data byte) {
+	value := cpu.Reg.R[A] - data
+	carryBits := cpu.Reg.R[A] ^ data ^ value
+	cpu.setCSub(cpu.Reg.R[A], data)
 	cpu.Reg.R[A] = value
 	cpu.setF(flagZ, value == 0)
 	cpu.setF(flagN, true)
 	cpu.setF(flagH, util.Bit(carryBits, 4))
+}
+
+func sub8(cpu *CPU, _, r8 int) {
+	cpu.subA(cpu.Reg.R[r8])
 	cpu.Reg.PC++
 }
 
-func (cpu *CPU) SUB(op1, _ int) {
-	switch op1 {
-	case OP_d8:
-		value := cpu.Reg.R[A] - cpu.d8Fetch()
-		carryBits := cpu.Reg.R[A] ^ cpu.d8Fetch() ^ value
-		cpu.setCSub(cpu.Reg.R[A], cpu.d8Fetch())
-		cpu.Reg.R[A] = value
-		cpu.setF(flagZ, value == 0)
-		cpu.setF(flagN, true)
-		cpu.setF(flagH, util.Bit(carryBits, 4))
-		cpu.Reg.PC += 2
-	case OP_HL_PAREN:
-		value := cpu.Reg.R[A] - cpu.FetchMemory8(cpu.Reg.HL())
-		carryBits := cpu.Reg.R[A] ^ cpu.FetchMemory8(cpu.Reg.HL()) ^ value
-		cpu.setCSub(cpu.Reg.R[A], cpu.FetchMemory8(cpu.Reg.HL()))
-		cpu.Reg.R[A] = value
-		cpu.setF(flagZ, value == 0)
-		cpu.setF(flagN, true)
-		cpu.setF(flagH, util.Bit(carryBits, 4))
-		cpu.Reg.PC++
-	}
+func sub8m(cpu *CPU, _, _ int) {
+	cpu.subA(cpu.FetchMemory8(cpu.Reg.HL()))
+	cpu.Reg.PC++
+}
+
+func sub8i(cpu *CPU, _, _ int) {
+	cpu.subA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
 }
 
 // Rotate register A right through carry.
@@ -1044,103 +982,71 @@ func rra(cpu *CPU, _, _ int) {
 }
 
 // ADC Add the value n8 plus the carry flag to A
-func adc8(cpu *CPU, _, op int) {
-	var carry, value, value4 byte
-	var value16 uint16
+func (cpu *CPU) adcA(data byte) {
+	var carry byte
 	if cpu.f(flagC) {
 		carry = 1
 	}
 
-	value = cpu.Reg.R[op] + carry + cpu.Reg.R[A]
-	value4 = (cpu.Reg.R[op] & 0b1111) + carry + (cpu.Reg.R[A] & 0b1111)
-	value16 = uint16(cpu.Reg.R[op]) + uint16(carry) + uint16(cpu.Reg.R[A])
+	value := data + carry + cpu.Reg.R[A]
+	value4 := (data & 0b1111) + carry + (cpu.Reg.R[A] & 0b1111)
+	value16 := uint16(data) + uint16(carry) + uint16(cpu.Reg.R[A])
 	cpu.Reg.R[A] = value
 
 	cpu.setF(flagZ, value == 0)
 	cpu.setF(flagN, false)
 	cpu.setF(flagH, util.Bit(value4, 4))
 	cpu.setF(flagC, util.Bit(value16, 8))
-	cpu.Reg.PC++
 }
 
-func (cpu *CPU) ADC(_, op2 int) {
-	var carry, value, value4 byte
-	var value16 uint16
-	if cpu.f(flagC) {
-		carry = 1
-	}
+func adc8(cpu *CPU, _, r8 int) {
+	cpu.adcA(cpu.Reg.R[r8])
+	cpu.Reg.PC++
+}
 
-	switch op2 {
-	case OP_HL_PAREN:
-		data := cpu.FetchMemory8(cpu.Reg.HL())
-		value = data + carry + cpu.Reg.R[A]
-		value4 = (data & 0x0f) + carry + (cpu.Reg.R[A] & 0b1111)
-		value16 = uint16(data) + uint16(cpu.Reg.R[A]) + uint16(carry)
-	case OP_d8:
-		data := cpu.d8Fetch()
-		value = data + carry + cpu.Reg.R[A]
-		value4 = (data & 0x0f) + carry + (cpu.Reg.R[A] & 0b1111)
-		value16 = uint16(data) + uint16(cpu.Reg.R[A]) + uint16(carry)
-		cpu.Reg.PC++
-	}
-	cpu.Reg.R[A] = value
-	cpu.setF(flagZ, value == 0)
-	cpu.setF(flagN, false)
-	cpu.setF(flagH, util.Bit(value4, 4))
-	cpu.setF(flagC, util.Bit(value16, 8))
+func adc8m(cpu *CPU, _, _ int) {
+	cpu.adcA(cpu.FetchMemory8(cpu.Reg.HL()))
 	cpu.Reg.PC++
 }
 
-// SBC Subtract the value n8 and the carry flag from A
+func adc8i(cpu *CPU, _, _ int) {
+	cpu.adcA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
+}
 
-func sbc8(cpu *CPU, _, op int) {
-	var carry, value, value4 byte
-	var value16 uint16
+// SBC Subtract the value n8 and the carry flag from A
+func (cpu *CPU) sbcA(data byte) {
+	var carry byte
 	if cpu.f(flagC) {
 		carry = 1
 	}
 
-	value = cpu.Reg.R[A] - (cpu.Reg.R[op] + carry)
-	value4 = (cpu.Reg.R[A] & 0b1111) - ((cpu.Reg.R[op] & 0b1111) + carry)
-	value16 = uint16(cpu.Reg.R[A]) - (uint16(cpu.Reg.R[op]) + uint16(carry))
+	value := cpu.Reg.R[A] - (data + carry)
+	value4 := (cpu.Reg.R[A] & 0b1111) - ((data & 0b1111) + carry)
+	value16 := uint16(cpu.Reg.R[A]) - (uint16(data) + uint16(carry))
 	cpu.Reg.R[A] = value
 
 	cpu.setF(flagZ, value == 0)
 	cpu.setF(flagN, true)
 	cpu.setF(flagH, util.Bit(value4, 4))
 	cpu.setF(flagC, util.Bit(value16, 8))
-	cpu.Reg.PC++
 }
 
-func (cpu *CPU) SBC(_, op2 int) {
-	var carry, value, value4 byte
-	var value16 uint16
-	if cpu.f(flagC) {
-		carry = 1
-	}
-
-	switch op2 {
-	case OP_HL_PAREN:
-		data := cpu.FetchMemory8(cpu.Reg.HL())
-		value = cpu.Reg.R[A] - (data + carry)
-		value4 = (cpu.Reg.R[A] & 0b1111) - ((data & 0x0f) + carry)
-		value16 = uint16(cpu.Reg.R[A]) - (uint16(data) + uint16(carry))
-	case OP_d8:
-		data := cpu.d8Fetch()
-		value = cpu.Reg.R[A] - (data + carry)
-		value4 = (cpu.Reg.R[A] & 0b1111) - ((data & 0x0f) + carry)
-		value16 = uint16(cpu.Reg.R[A]) - (uint16(data) + uint16(carry))
-		cpu.Reg.PC++
-	}
-	cpu.Reg.R[A] = value
+func sbc8(cpu *CPU, _, r8 int) {
+	cpu.sbcA(cpu.Reg.R[r8])
+	cpu.Reg.PC++
+}
 
-	cpu.setF(flagZ, value == 0)
-	cpu.setF(flagN, true)
-	cpu.setF(flagH, util.Bit(value4, 4))
-	cpu.setF(flagC, util.Bit(value16, 8))
+func sbc8m(cpu *CPU, _, _ int) {
+	cpu.sbcA(cpu.FetchMemory8(cpu.Reg.HL()))
 	cpu.Reg.PC++
 }
 
+func sbc8i(cpu *CPU, _, _ int) {
+	cpu.sbcA(cpu.d8Fetch())
+	cpu.Reg.PC += 2
+}
+
 // DAA Decimal adjust
 func daa(cpu *CPU, _, _ int) {
 	a := uint8(cpu.Reg.R[A])
@@ -1189,3 +1095,8 @@ func ccf(cpu *CPU, _, _ int) {
 	cpu.setF(flagC, !cpu.f(flagC))
 	cpu.Reg.PC++
 }
+
+// invalid opcode hangs CPU until reset. Interrupts can't wake it up.
+func lock(cpu *CPU, _, _ int) {
+	cpu.locked = true
+}
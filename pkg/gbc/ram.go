@@ -4,23 +4,16 @@ import (
 	"gbc/pkg/cartridge"
 )
 
-var done = make(chan int)
-
 // FetchMemory8 fetch value from ram
 func (cpu *CPU) FetchMemory8(addr uint16) (value byte) {
+	if page := cpu.memory.read[addr>>8]; page != nil {
+		return page[addr&0xff]
+	}
+
 	switch {
-	case addr >= 0x4000 && addr < 0x8000: // rom bank
-		value = cpu.ROMBank.bank[cpu.ROMBank.ptr][addr-0x4000]
-	case addr >= 0x8000 && addr < 0xa000: // vram bank
-		value = cpu.GPU.VRAM.Bank[cpu.GPU.VRAM.Ptr][addr-0x8000]
-	case addr >= 0xa000 && addr < 0xc000: // rtc or ram bank
-		if cpu.RTC.Mapped != 0 {
-			value = cpu.RTC.Read(byte(cpu.RTC.Mapped))
-		} else {
-			value = cpu.RAMBank.bank[cpu.RAMBank.ptr][addr-0xa000]
-		}
-	case cpu.WRAMBank.ptr > 1 && addr >= 0xd000 && addr < 0xe000: // wram bank
-		value = cpu.WRAMBank.bank[cpu.WRAMBank.ptr][addr-0xd000]
+	case addr >= 0xa000 && addr < 0xc000: // rtc
+		cpu.syncRTC()
+		value = cpu.RTC.Read(byte(cpu.RTC.Mapped))
 	case addr >= 0xff00:
 		value = cpu.fetchIO(addr)
 	default:
@@ -36,9 +29,18 @@ func (cpu *CPU) fetchIO(addr uint16) (value byte) {
 	case addr == SBIO:
 		value = cpu.Serial.ReadSB()
 	case addr == SCIO:
-		value = cpu.Serial.ReadSC()
-	case (addr >= 0xff10 && addr <= 0xff26) || (addr >= 0xff30 && addr <= 0xff3f): // sound IO
+		value = cpu.readSC()
+	case addr == DIVIO:
+		value = cpu.fetchDIV()
+	case addr >= 0xff10 && addr <= 0xff3f: // sound IO
+		cpu.syncSound()
 		value = cpu.Sound.Read(addr)
+	case addr >= HDMA1IO && addr <= HDMA4IO: // write only
+		value = 0xff
+	case addr == HDMA5IO:
+		value = cpu.readHDMA5()
+	case addr == RPIO:
+		value = cpu.readRP()
 	case addr == LCDCIO:
 		value = cpu.GPU.LCDC
 	case addr == LCDSTATIO:
@@ -55,6 +57,10 @@ func (cpu *CPU) fetchIO(addr uint16) (value byte) {
 
 // SetMemory8 set value into RAM
 func (cpu *CPU) SetMemory8(addr uint16, value byte) {
+	if page := cpu.memory.write[addr>>8]; page != nil && !cpu.oamDMABusy() {
+		page[addr&0xff] = value
+		return
+	}
 
 	if addr <= 0x7fff { // rom
 		if (addr >= 0x2000) && (addr <= 0x3fff) {
@@ -68,13 +74,11 @@ func (cpu *CPU) SetMemory8(addr uint16, value byte) {
 				newROMBankPtr := (upper2 << 5) | lower5
 				cpu.switchROMBank(newROMBankPtr)
 			case cartridge.MBC3:
-				if cpu.GPU.HBlankDMALength == 0 {
-					newROMBankPtr := value & 0x7f
-					if newROMBankPtr == 0 {
-						newROMBankPtr++
-					}
-					cpu.switchROMBank(newROMBankPtr)
+				newROMBankPtr := value & 0x7f
+				if newROMBankPtr == 0 {
+					newROMBankPtr++
 				}
+				cpu.switchROMBank(newROMBankPtr)
 			case cartridge.MBC5:
 				if addr < 0x3000 { // lower 8bit
 					cpu.switchROMBank(value)
@@ -89,20 +93,24 @@ func (cpu *CPU) SetMemory8(addr uint16, value byte) {
 					newROMBankPtr := (upper2 << 5) | lower5
 					cpu.switchROMBank(newROMBankPtr)
 				} else if cpu.bankMode == 1 { // switch RAMptr
-					newRAMBankPtr := value
+					newRAMBankPtr := value & 0x03
 					cpu.RAMBank.ptr = newRAMBankPtr
+					cpu.mapRAMBank()
 				}
 			case cartridge.MBC3:
 				switch {
-				case value <= 0x07 && cpu.GPU.HBlankDMALength == 0:
+				case value <= 0x07:
 					cpu.RTC.Mapped = 0
 					cpu.RAMBank.ptr = value
+					cpu.mapRAMBank()
 				case value >= 0x08 && value <= 0x0c:
 					cpu.RTC.Mapped = uint(value)
+					cpu.mapRAMBank()
 				}
 			case cartridge.MBC5:
 				// fmt.Println(value)
 				cpu.RAMBank.ptr = value & 0x0f
+				cpu.mapRAMBank()
 			}
 		} else if (addr >= 0x6000) && (addr <= 0x7fff) {
 			switch cpu.Cartridge.MBC {
@@ -116,6 +124,7 @@ func (cpu *CPU) SetMemory8(addr uint16, value byte) {
 					cpu.RTC.Latched = false
 				} else if value == 0 {
 					cpu.RTC.Latched = true
+					cpu.syncRTC()
 					cpu.RTC.Latch()
 				}
 			}
@@ -123,22 +132,18 @@ func (cpu *CPU) SetMemory8(addr uint16, value byte) {
 	} else {
 
 		if addr < 0xff80 || addr > 0xfffe { // only 0xff80-0xfffe can be accessed during OAMDMA
-			if cpu.OAMDMA.ptr > 0 && cpu.OAMDMA.ptr <= 160 {
+			if cpu.oamDMABusy() {
 				return
 			}
 		}
 
 		switch {
-		case addr >= 0x8000 && addr < 0xa000: // vram
-			cpu.GPU.VRAM.Bank[cpu.GPU.VRAM.Ptr][addr-0x8000] = value
-		case addr >= 0xa000 && addr < 0xc000: // rtc or ram
-			if cpu.RTC.Mapped == 0 {
-				cpu.RAMBank.bank[cpu.RAMBank.ptr][addr-0xa000] = value
-			} else {
-				cpu.RTC.Write(byte(cpu.RTC.Mapped), value)
-			}
-		case cpu.WRAMBank.ptr > 1 && addr >= 0xd000 && addr < 0xe000: // wram
-			cpu.WRAMBank.bank[cpu.WRAMBank.ptr][addr-0xd000] = value
+		case addr >= 0xa000 && addr < 0xc000 && cpu.RTC.Mapped == 0: // clean sram
+			cpu.writeSRAM(addr, value)
+		case addr >= 0xa000 && addr < 0xc000: // rtc
+			cpu.syncRTC()
+			cpu.RTC.Write(byte(cpu.RTC.Mapped), value)
+			cpu.markSRAMDirty()
 		case addr >= 0xff00:
 			cpu.setIO(addr, value)
 		default:
@@ -148,6 +153,9 @@ func (cpu *CPU) SetMemory8(addr uint16, value byte) {
 }
 
 func (cpu *CPU) setIO(addr uint16, value byte) {
+	if addr == TACIO {
+		cpu.syncTAC() // normal timer counts with old TAC until now
+	}
 	cpu.RAM[addr] = value
 
 	switch {
@@ -157,68 +165,18 @@ func (cpu *CPU) setIO(addr uint16, value byte) {
 	case addr == SBIO:
 		cpu.Serial.WriteSB(value)
 	case addr == SCIO:
-
-		if cpu.Serial.TransferFlag == 0 {
-			cpu.Serial.WriteSC(value)
-
-			switch value {
-			case 0x80:
-				if cpu.Serial.WaitCtr > 0 {
-					cpu.Serial.Wait.Done()
-					cpu.Serial.WaitCtr--
-				}
-			case 0x81:
-				close(done)
-				done = make(chan int)
-				go func() {
-					success := false
-					for !success {
-						success = cpu.Serial.Transfer(0)
-						select {
-						case <-done:
-							// if next transfer is requested, stop forcibly
-						default:
-						}
-					}
-					if success {
-						cpu.Serial.TransferFlag = 1
-						<-cpu.serialTick
-						cpu.Serial.Receive()
-						cpu.Serial.ClearSC()
-						cpu.setSerialFlag(true)
-					}
-				}()
-			case 0x83:
-				close(done)
-				done = make(chan int)
-				go func() {
-					success := false
-					for !success {
-						success = cpu.Serial.Transfer(0)
-						select {
-						case <-done:
-						default:
-						}
-					}
-					if success {
-						cpu.Serial.TransferFlag = 1
-						<-cpu.serialTick
-						cpu.Serial.Receive()
-						cpu.Serial.ClearSC()
-						cpu.setSerialFlag(true)
-					}
-				}()
-			}
-		}
+		cpu.writeSC(value)
 
 	case addr == DIVIO:
 		cpu.Timer.ResetAll = true
+		cpu.scheduler.schedule(eventTimerWrite, 1)
 
 	case addr == TIMAIO:
 		if cpu.TIMAReload.flag {
 			cpu.TIMAReload.flag = false
+			cpu.scheduler.cancel(eventTIMAReload)
 			cpu.RAM[TIMAIO] = value
-		} else if cpu.TIMAReload.after {
+		} else if cpu.timaReloaded() {
 			cpu.RAM[TIMAIO] = cpu.TIMAReload.value
 		} else {
 			cpu.RAM[TIMAIO] = value
@@ -227,7 +185,7 @@ func (cpu *CPU) setIO(addr uint16, value byte) {
 	case addr == TMAIO:
 		if cpu.TIMAReload.flag {
 			cpu.TIMAReload.value = value
-		} else if cpu.TIMAReload.after {
+		} else if cpu.timaReloaded() {
 			cpu.RAM[TIMAIO] = value
 		}
 		cpu.RAM[TMAIO] = value
@@ -236,6 +194,8 @@ func (cpu *CPU) setIO(addr uint16, value byte) {
 		cpu.Timer.TAC.Change = true
 		cpu.Timer.TAC.Old = cpu.RAM[TACIO]
 		cpu.RAM[TACIO] = value
+		cpu.scheduleTimerTick()
+		cpu.scheduler.schedule(eventTimerWrite, 1)
 
 	case addr == IFIO:
 		cpu.RAM[IFIO] = value | 0xe0 // IF[4-7] always set
@@ -248,11 +208,14 @@ func (cpu *CPU) setIO(addr uint16, value byte) {
 		} else {
 			cpu.OAMDMA.start = start
 			cpu.OAMDMA.ptr = 160 + 2 // lag
+			cpu.scheduler.schedule(eventOAMDMA, 1)
 		}
 
-	case addr >= 0xff10 && addr <= 0xff26: // sound io
+	case addr >= 0xff10 && addr <= 0xff2f: // sound io
+		cpu.syncSound()
 		cpu.Sound.Write(addr, value)
 	case addr >= 0xff30 && addr <= 0xff3f: // sound io
+		cpu.syncSound()
 		cpu.Sound.WriteWaveform(addr, value)
 
 	case addr == LCDCIO:
@@ -274,27 +237,15 @@ func (cpu *CPU) setIO(addr uint16, value byte) {
 		cpu.GPU.Palette.DMGPalette[2] = value
 
 	// below case statements, gbc only
-	case addr == VBKIO && cpu.GPU.HBlankDMALength == 0: // switch vram bank
+	case addr == VBKIO: // switch vram bank
 		cpu.GPU.VRAM.Ptr = value & 0x01
+		cpu.mapVRAMBank()
 
 	case addr == HDMA5IO:
-		HDMA5 := value
-		mode := HDMA5 >> 7 // transfer mode
-		if cpu.GPU.HBlankDMALength > 0 && mode == 0 {
-			cpu.GPU.HBlankDMALength = 0
-			cpu.RAM[HDMA5IO] |= 0x80
-		} else {
-			length := (int(HDMA5&0x7f) + 1) * 16 // transfer size
+		cpu.writeHDMA5(value)
 
-			switch mode {
-			case 0: // generic dma
-				cpu.doVRAMDMATransfer(length)
-				cpu.RAM[HDMA5IO] = 0xff // complete
-			case 1: // hblank dma
-				cpu.GPU.HBlankDMALength = int(HDMA5&0x7f) + 1
-				cpu.RAM[HDMA5IO] &= 0x7f
-			}
-		}
+	case addr == RPIO: // infrared
+		cpu.writeRP(value)
 
 	case addr == BCPSIO:
 		cpu.GPU.Palette.CGBPalette[0] = value
@@ -317,6 +268,7 @@ func (cpu *CPU) setIO(addr uint16, value byte) {
 			newWRAMBankPtr++
 		}
 		cpu.WRAMBank.ptr = newWRAMBankPtr
+		cpu.mapWRAMBank()
 	}
 }
 
@@ -324,20 +276,6 @@ func (cpu *CPU) switchROMBank(newROMBankPtr uint8) {
 	switchFlag := (newROMBankPtr < (2 << cpu.Cartridge.ROMSize))
 	if switchFlag {
 		cpu.ROMBank.ptr = newROMBankPtr
+		cpu.mapROMBank()
 	}
 }
-
-func (cpu *CPU) doVRAMDMATransfer(length int) {
-	from := (uint16(cpu.RAM[HDMA1IO])<<8 | uint16(cpu.RAM[HDMA2IO])) & 0xfff0
-	to := ((uint16(cpu.RAM[HDMA3IO])<<8 | uint16(cpu.RAM[HDMA4IO])) & 0x1ff0) + 0x8000
-
-	for i := 0; i < length; i++ {
-		value := cpu.FetchMemory8(from)
-		cpu.SetMemory8(to, value)
-		from++
-		to++
-	}
-
-	cpu.RAM[HDMA1IO], cpu.RAM[HDMA2IO] = byte(from>>8), byte(from)
-	cpu.RAM[HDMA3IO], cpu.RAM[HDMA4IO] = byte(to>>8), byte(to&0xf0)
-}
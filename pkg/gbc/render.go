@@ -8,7 +8,6 @@ import (
 	"image"
 	"image/color"
 	"image/png"
-	"time"
 
 	ebiten "github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
@@ -25,13 +24,6 @@ const (
 	debugHeight = 740.
 )
 
-var (
-	frames     = 0
-	second     = time.Tick(time.Second)
-	skipRender bool
-	fps        = 0
-)
-
 func setIcon() {
 	buf := bytes.NewBuffer(icon)
 	img, _ := png.Decode(buf)
@@ -52,7 +44,7 @@ func (cpu *CPU) renderScreen(screen *ebiten.Image) {
 		}
 
 		// debug FPS
-		title := fmt.Sprintf("GameBoy FPS: %d", fps)
+		title := fmt.Sprintf("GameBoy FPS: %d", cpu.frame.fps)
 		ebitenutil.DebugPrintAt(dScreen, title, 10, 5)
 
 		// debug register
@@ -96,15 +88,23 @@ func (cpu *CPU) renderScreen(screen *ebiten.Image) {
 			cpu.debugPrintOAM(dScreen)
 		}
 
+		// debug sound
+		cpu.debugPrintSound(dScreen)
+
 		op := &ebiten.DrawImageOptions{}
 		screen.DrawImage(dScreen, op)
 		return
 	}
 
-	if !skipRender && cpu.Config.Display.HQ2x {
-		display = cpu.GPU.HQ2x()
+	screen.ReplacePixels(cpu.screen().Pix)
+}
+
+// screen returns game screen (HQ2x is applied if enabled)
+func (cpu *CPU) screen() *image.RGBA {
+	if !cpu.frame.skipRender && cpu.Config.Display.HQ2x {
+		return cpu.GPU.HQ2x()
 	}
-	screen.ReplacePixels(display.Pix)
+	return cpu.GPU.Display(cpu.Config.Display.HQ2x)
 }
 
 func (cpu *CPU) handleJoypad() {
@@ -0,0 +1,118 @@
+package gbc
+
+import (
+	"image"
+	_ "image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testROMs returns test/**/rom.gb and the name of each ROM (e.g. mooneye-gb/timer/tim00)
+func testROMs(tb testing.TB) (paths, names []string) {
+	root, err := filepath.Abs("../../test")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == "rom.gb" {
+			paths = append(paths, path)
+			names = append(names, filepath.ToSlash(strings.TrimPrefix(filepath.Dir(path), root+string(filepath.Separator))))
+		}
+		return nil
+	})
+	if len(paths) == 0 {
+		tb.Skip("no test ROM")
+	}
+	return paths, names
+}
+
+// chdirTemp changes working directory into temporary directory because config.toml is created there
+func chdirTemp(tb testing.TB) string {
+	cur, _ := os.Getwd()
+	tmp, err := ioutil.TempDir("", "gbc")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	os.Chdir(tmp)
+	tb.Cleanup(func() {
+		os.Chdir(cur)
+		os.RemoveAll(tmp)
+	})
+	return tmp
+}
+
+// newTestCPU returns CPU which runs ${rom} headless like `gbc --test`
+func newTestCPU(tb testing.TB, rom []byte, romdir string) *CPU {
+	cpu := &CPU{}
+	cpu.Cartridge.ParseCartridge(rom)
+	cpu.TransferROM(rom)
+	cpu.Init(romdir, false, true)
+	return cpu
+}
+
+func loadJPEG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// sameScreen compares screens allowing small differences of JPEG compression
+func sameScreen(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	diff := func(p, q uint32) bool { return p > q+0x800 || q > p+0x800 }
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			if diff(r1, r2) || diff(g1, g2) || diff(b1, b2) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestROM runs each test ROM which has expected.jpg for 30 seconds like `make test` and compares the last screen
+func TestROM(t *testing.T) {
+	if testing.Short() {
+		t.Skip("test ROMs take a while")
+	}
+	paths, names := testROMs(t)
+	tmp := chdirTemp(t)
+	for i, path := range paths {
+		expected, err := loadJPEG(filepath.Join(filepath.Dir(path), "expected.jpg"))
+		if os.IsNotExist(err) { // not in `make test`
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rom, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(names[i], func(t *testing.T) {
+			actual := filepath.Join(tmp, "actual.jpg")
+			if err := newTestCPU(t, rom, tmp).DebugExec(30*60, actual); err != nil {
+				t.Fatal(err)
+			}
+			screen, err := loadJPEG(actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameScreen(screen, expected) {
+				t.Error("screen differs from expected.jpg")
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package gbc
+
+// syncRTC advances RTC until now. RTC runs at the same real speed in double speed mode.
+func (cpu *CPU) syncRTC() {
+	elapsed := (cpu.scheduler.now - cpu.rtcAt) / uint64(cpu.boost)
+	cpu.rtcAt += elapsed * uint64(cpu.boost)
+	if cpu.RTC.Enable {
+		cpu.RTC.Advance(int(elapsed))
+	}
+}
@@ -4,94 +4,217 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+
+	"gbc/pkg/rtc"
 )
 
+// sram - state of battery backed RAM
+type sram struct {
+	dirty    bool // SRAM is written after the last save. While it's clean, writes to 0xa000-0xbfff go through SetMemory8.
+	dirtyFor int  // seconds since SRAM got dirty
+	rotated  bool // backups are already rotated in this session
+}
+
 // GameBoy save data is SRAM core dump
 func (cpu *CPU) save() {
-	savname := fmt.Sprintf("%s/%s.sav", cpu.romdir, cpu.Cartridge.Title)
-	savfile, err := os.Create(savname)
-	if err != nil {
+	if cpu.gbs != nil { // GBS has no save data
+		return
+	}
+	// RTC footer has the time when saved, so it's written even if SRAM isn't changed
+	if !cpu.RTC.Enable && (cpu.sramSize() == 0 || !cpu.sram.dirty) {
 		return
 	}
-	defer savfile.Close()
 
-	var savdata []byte
-	switch cpu.Cartridge.RAMSize {
-	case 1:
-		savdata = make([]byte, 0x800)
-		for index := 0; index < 0x800; index++ {
-			savdata[index] = cpu.RAMBank.bank[0][index]
-		}
-	case 2:
-		savdata = make([]byte, 0x2000*1)
-		for index := 0; index < 0x2000; index++ {
-			savdata[index] = cpu.RAMBank.bank[0][index]
-		}
-	case 3:
-		savdata = make([]byte, 0x2000*4)
-		for i := 0; i < 4; i++ {
-			for j := 0; j < 0x2000; j++ {
-				index := i*0x2000 + j
-				savdata[index] = cpu.RAMBank.bank[i][j]
-			}
-		}
-	case 5:
-		savdata = make([]byte, 0x2000*8)
-		for i := 0; i < 8; i++ {
-			for j := 0; j < 0x2000; j++ {
-				index := i*0x2000 + j
-				savdata[index] = cpu.RAMBank.bank[i][j]
-			}
-		}
+	if err := cpu.writeSave(); err != nil {
+		fmt.Fprintf(os.Stderr, "Save Error: %s\n", err)
 	}
+}
 
+// writeSave writes SRAM and RTC footer to save data
+func (cpu *CPU) writeSave() error {
+	savdata := cpu.dumpSRAM()
 	if cpu.RTC.Enable {
-		rtcData := cpu.RTC.Dump()
-		for i := 0; i < 48; i++ {
-			savdata = append(savdata, rtcData[i])
-		}
+		cpu.syncRTC()
+		savdata = append(savdata, cpu.RTC.Dump()...)
 	}
 
-	_, err = savfile.Write(savdata)
-	if err != nil {
-		return
+	// backups keep save data of the previous sessions, so they are rotated only once per session
+	path := cpu.savePath()
+	if !cpu.sram.rotated {
+		if err := rotateBackups(path, cpu.Config.Save.Backups); err != nil { // save data is still written
+			fmt.Fprintf(os.Stderr, "Save Error: %s\n", err)
+		}
+		cpu.sram.rotated = true
+	}
+	if err := writeAtomic(path, savdata); err != nil {
+		return err
 	}
+	cpu.sram.dirty, cpu.sram.dirtyFor = false, 0
+	cpu.mapRAMBank()
+	return nil
 }
 
-func (cpu *CPU) load() {
-	savname := fmt.Sprintf("%s/%s.sav", cpu.romdir, cpu.Cartridge.Title)
-	savdata, err := ioutil.ReadFile(savname)
+// load restores save data. Data which doesn't fit SRAM is loaded as much as possible with warning. Use -import to convert it.
+func (cpu *CPU) load() error {
+	if cpu.gbs != nil {
+		return nil
+	}
+	savdata, err := ioutil.ReadFile(cpu.savePath())
+	if os.IsNotExist(err) { // save data of old versions is named after the title in ROM header
+		savdata, err = ioutil.ReadFile(cpu.legacySavePath())
+	}
 	if err != nil {
-		return
+		return err
+	}
+
+	size := cpu.sramSize()
+	switch rest := len(savdata) - size; {
+	case rest < 0:
+		fmt.Fprintf(os.Stderr, "Save Error: save data is %d bytes, but SRAM is %d bytes\n", len(savdata), size)
+	case rest > 0 && !(cpu.RTC.Enable && rtc.IsFooter(rest)):
+		fmt.Fprintf(os.Stderr, "Save Error: %d bytes after SRAM are ignored\n", rest)
+	}
+	cpu.restoreSRAM(savdata)
+
+	// RTC footer follows SRAM. MBC3+TIMER+BATTERY has only footer.
+	if cpu.RTC.Enable && len(savdata) > size && rtc.IsFooter(len(savdata)-size) {
+		cpu.RTC.Sync(savdata[size:])
 	}
+	return nil
+}
+
+// sramSize returns bytes of SRAM in save data
+func (cpu *CPU) sramSize() int {
 	switch cpu.Cartridge.RAMSize {
 	case 1:
-		for index := 0; index < 0x800; index++ {
-			cpu.RAMBank.bank[0][index] = savdata[index]
-		}
+		return 0x800
 	case 2:
-		for index := 0; index < 0x2000; index++ {
-			cpu.RAMBank.bank[0][index] = savdata[index]
-		}
+		return 0x2000
 	case 3:
-		for i := 0; i < 4; i++ {
-			for j := 0; j < 0x2000; j++ {
-				index := i*0x2000 + j
-				cpu.RAMBank.bank[i][j] = savdata[index]
-			}
-		}
+		return 0x2000 * 4
+	case 4:
+		return 0x2000 * 16
 	case 5:
-		for i := 0; i < 8; i++ {
-			for j := 0; j < 0x2000; j++ {
-				index := i*0x2000 + j
-				cpu.RAMBank.bank[i][j] = savdata[index]
-			}
-		}
+		return 0x2000 * 8
+	}
+	return 0
+}
+
+func (cpu *CPU) dumpSRAM() []byte {
+	data := make([]byte, cpu.sramSize())
+	for i := 0; i < len(data); i += 0x2000 {
+		copy(data[i:], cpu.RAMBank.bank[i/0x2000][:])
 	}
+	return data
+}
+
+// restoreSRAM copies ${data} into SRAM. The rest is left as it is if ${data} is short.
+func (cpu *CPU) restoreSRAM(data []byte) {
+	size := cpu.sramSize()
+	if len(data) > size {
+		data = data[:size]
+	}
+	for i := 0; i < len(data); i += 0x2000 {
+		copy(cpu.RAMBank.bank[i/0x2000][:], data[i:])
+	}
+}
+
+// writeSRAM handles write to SRAM while it's clean
+func (cpu *CPU) writeSRAM(addr uint16, value byte) {
+	cpu.RAMBank.bank[cpu.RAMBank.ptr][addr-0xa000] = value
+	cpu.markSRAMDirty()
+}
+
+func (cpu *CPU) markSRAMDirty() {
+	if !cpu.sram.dirty {
+		cpu.sram.dirty, cpu.sram.dirtyFor = true, 0
+		cpu.mapRAMBank()
+	}
+}
 
-	if len(savdata) >= 0x1000 && len(savdata)%0x1000 == 48 {
-		start := (len(savdata) / 0x1000) * 0x1000
-		rtcData := savdata[start : start+48]
-		cpu.RTC.Sync(rtcData)
+// autosave is called every second. It saves the game after SRAM has been dirty for [save] autosave seconds.
+func (cpu *CPU) autosave() {
+	interval := cpu.Config.Save.Autosave
+	if !cpu.sram.dirty || interval <= 0 {
+		return
+	}
+	cpu.sram.dirtyFor++
+	if cpu.sram.dirtyFor >= interval {
+		cpu.save()
+	}
+}
+
+// savePath returns ${save_dir}/${ROM file name}.sav. save_dir is ROM directory by default.
+// Player 2 in split screen has its own save data.
+func (cpu *CPU) savePath() string {
+	name := cpu.ROMName
+	if name == "" {
+		name = cpu.Cartridge.Title
+	}
+	if cpu.Player == 2 {
+		name += "_2"
+	}
+	dir := cpu.Config.Save.Dir
+	if dir == "" {
+		dir = cpu.romdir
+	}
+	return filepath.Join(dir, name+".sav")
+}
+
+func (cpu *CPU) legacySavePath() string {
+	if cpu.Player == 2 {
+		return fmt.Sprintf("%s/%s_2.sav", cpu.romdir, cpu.Cartridge.Title)
+	}
+	return fmt.Sprintf("%s/%s.sav", cpu.romdir, cpu.Cartridge.Title)
+}
+
+// writeAtomic writes ${data} to temporary file and renames it, so ${path} is never half written
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rotateBackups copies ${path} to ${path}.bak1 and shifts older backups up to ${path}.bak${n}
+func rotateBackups(path string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	backup := func(i int) string { return fmt.Sprintf("%s.bak%d", path, i) }
+	os.Remove(backup(n))
+	for i := n - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
+	return writeAtomic(backup(1), data)
 }
@@ -0,0 +1,137 @@
+package gbc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gbc/pkg/config"
+	"gbc/pkg/rtc"
+)
+
+// Import and export of save data of other emulators and flash carts (.sav, .srm, .sa1)
+// They are SRAM dump, but some are padded to power of 2 and some have RTC footer of 48 or 44 bytes.
+
+// RTC footer formats of export
+const (
+	Footer48   = "48"   // VBA-M, BGB, mGBA, SameBoy
+	Footer44   = "44"   // old VBA
+	FooterNone = "none" // flash carts, Analogue Pocket
+)
+
+// OpenSave loads config and save data of the ROM without starting emulation
+func (cpu *CPU) OpenSave(romdir string) error {
+	cpu.Config = config.Init()
+	cpu.boost = 1
+	cpu.romdir = romdir
+	cpu.RTC.SyncHost = cpu.Config.RTC.SyncHost
+	return cpu.load()
+}
+
+// ImportSave converts save data at ${path} and replaces the save data of the ROM. It returns report of what was done.
+func (cpu *CPU) ImportSave(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	size := cpu.sramSize()
+	if size == 0 && !cpu.RTC.Enable {
+		return nil, errors.New("this cartridge has neither SRAM nor RTC")
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("%s is %d bytes, but SRAM of this cartridge is %d bytes (RAM size: 0x%02x)", path, len(data), size, cpu.Cartridge.RAMSize)
+	}
+
+	report := []string{fmt.Sprintf("Import: %s (%d bytes)", path, len(data))}
+	footer, padding, err := splitFooter(data[size:])
+	if err != nil {
+		return nil, err
+	}
+	report = append(report, fmt.Sprintf("  SRAM: %d bytes", size))
+	if padding > 0 {
+		report = append(report, fmt.Sprintf("  Padding: %d bytes are removed", padding))
+	}
+
+	switch {
+	case footer == nil && cpu.RTC.Enable:
+		report = append(report, fmt.Sprintf("  RTC: no footer, the clock keeps the current value (%s)", &cpu.RTC))
+	case footer != nil && !cpu.RTC.Enable:
+		report = append(report, fmt.Sprintf("  RTC: %d bytes footer is removed because this cartridge has no RTC", len(footer)))
+	case footer != nil:
+		cpu.RTC.Sync(footer)
+		report = append(report, fmt.Sprintf("  RTC: %d bytes footer saved at %s is converted to %d bytes (%s)",
+			len(footer), rtc.FooterTime(footer).Format("2006-01-02 15:04:05"), rtc.FooterSize, &cpu.RTC))
+	}
+
+	savePath := cpu.savePath()
+	_, err = os.Stat(savePath)
+	backup := err == nil && !cpu.sram.rotated && cpu.Config.Save.Backups > 0
+	cpu.restoreSRAM(data[:size])
+	if err := cpu.writeSave(); err != nil {
+		return nil, err
+	}
+	report = append(report, fmt.Sprintf("  Saved: %s", savePath))
+	if backup {
+		report = append(report, fmt.Sprintf("  Backup: the previous save data is kept as %s.bak1", savePath))
+	}
+	return report, nil
+}
+
+// ExportSave writes save data of the ROM to ${path} with RTC ${footer} (Footer48, Footer44 or FooterNone).
+// It returns report of what was done.
+func (cpu *CPU) ExportSave(path, footer string) ([]string, error) {
+	if footer != Footer48 && footer != Footer44 && footer != FooterNone {
+		return nil, fmt.Errorf("unknown RTC footer format: %s", footer)
+	}
+	if cpu.sramSize() == 0 && !cpu.RTC.Enable {
+		return nil, errors.New("this cartridge has neither SRAM nor RTC")
+	}
+
+	data := cpu.dumpSRAM()
+	report := []string{fmt.Sprintf("Export: %s", path), fmt.Sprintf("  SRAM: %d bytes", len(data))}
+	switch {
+	case !cpu.RTC.Enable:
+		if footer != FooterNone {
+			report = append(report, "  RTC: no footer because this cartridge has no RTC")
+		}
+	case footer == FooterNone:
+		report = append(report, fmt.Sprintf("  RTC: footer is omitted, the clock (%s) isn't exported", &cpu.RTC))
+	default:
+		dump := cpu.RTC.Dump()
+		if footer == Footer44 {
+			dump = dump[:rtc.FooterSize32] // 32bit timestamp is the lower half of 64bit one
+		}
+		data = append(data, dump...)
+		report = append(report, fmt.Sprintf("  RTC: %d bytes footer (%s)", len(dump), &cpu.RTC))
+	}
+
+	if err := writeAtomic(path, data); err != nil {
+		return nil, err
+	}
+	report = append(report, fmt.Sprintf("  Total: %d bytes", len(data)))
+	return report, nil
+}
+
+// splitFooter finds RTC footer in ${rest} after SRAM. Padding of 0x00 or 0xff may be between SRAM and footer.
+func splitFooter(rest []byte) (footer []byte, padding int, err error) {
+	for _, n := range []int{rtc.FooterSize, rtc.FooterSize32} {
+		if len(rest) >= n && rtc.LooksLikeFooter(rest[len(rest)-n:]) && isPadding(rest[:len(rest)-n]) {
+			return rest[len(rest)-n:], len(rest) - n, nil
+		}
+	}
+	if isPadding(rest) {
+		return nil, len(rest), nil
+	}
+	return nil, 0, fmt.Errorf("unknown %d bytes after SRAM", len(rest))
+}
+
+// isPadding returns true if ${data} is filled with 0x00 or 0xff
+func isPadding(data []byte) bool {
+	for _, b := range data {
+		if b != data[0] || (b != 0x00 && b != 0xff) {
+			return false
+		}
+	}
+	return true
+}
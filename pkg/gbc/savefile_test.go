@@ -0,0 +1,46 @@
+package gbc
+
+import (
+	"bytes"
+	"testing"
+
+	"gbc/pkg/rtc"
+)
+
+func TestSplitFooter(t *testing.T) {
+	clock := &rtc.RTC{Ctr: [5]byte{12, 34, 5, 0x10, 0x01}}
+	footer48 := clock.Dump()
+	footer44 := footer48[:rtc.FooterSize32]
+	pad := func(b byte, n int) []byte { return bytes.Repeat([]byte{b}, n) }
+	join := func(a, b []byte) []byte { return append(append([]byte{}, a...), b...) }
+
+	tests := []struct {
+		name    string
+		rest    []byte
+		footer  []byte
+		padding int
+		err     bool
+	}{
+		{"nothing", nil, nil, 0, false},
+		{"padding 0x00", pad(0x00, 0x1000), nil, 0x1000, false},
+		{"padding 0xff", pad(0xff, 100), nil, 100, false},
+		{"48 bytes", footer48, footer48, 0, false},
+		{"44 bytes", footer44, footer44, 0, false},
+		{"padding and 48 bytes", join(pad(0xff, 16), footer48), footer48, 16, false},
+		{"padding and 44 bytes", join(pad(0x00, 20), footer44), footer44, 20, false},
+		{"4 bytes of padding and 44 bytes", join(pad(0x00, 4), footer44), footer44, 4, false},
+		{"garbage", []byte("this is not a footer"), nil, 0, true},
+		{"garbage and 48 bytes", join([]byte{0x12, 0x34}, footer48), nil, 0, true},
+		{"mixed padding", join(pad(0x00, 8), pad(0xff, 8)), nil, 0, true},
+	}
+	for _, tt := range tests {
+		footer, padding, err := splitFooter(tt.rest)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(footer, tt.footer) || padding != tt.padding {
+			t.Errorf("%s: %d bytes footer after %d bytes padding, want %d bytes after %d bytes", tt.name, len(footer), padding, len(tt.footer), tt.padding)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package gbc
+
+// Events are ordered by cycle first and then by kind, so events that fall on the same cycle
+// run in the same order as the steps of the old per-cycle tick loop.
+const (
+	eventTimerWrite    = iota // DIV or TAC was written
+	eventIMESwitch            // delayed IME change by EI
+	eventSerialBit            // serial clock shifts 1 bit, or link port is polled
+	eventTimerTick            // TAC clock reaches its period
+	eventTIMAReload           // TIMA is reloaded from TMA one cycle after overflow
+	eventTIMAIncrement        // TIMA increment caused by TAC clock or DIV/TAC write
+	eventOAMDMA               // one OAM DMA step
+	eventAPUFrame             // APU frame sequencer step
+	eventPPU                  // PPU mode change
+	eventNum
+)
+
+type event struct {
+	when  uint64
+	kind  int
+	index int // position in queue, -1 if not scheduled
+}
+
+// Scheduler - priority queue of events. CPU runs freely until the next event.
+type Scheduler struct {
+	now    uint64 // M-cycles since power on
+	events [eventNum]event
+	queue  []*event // binary min-heap
+}
+
+func (s *Scheduler) init() {
+	s.queue = make([]*event, 0, eventNum)
+	for i := range s.events {
+		s.events[i] = event{kind: i, index: -1}
+	}
+}
+
+func (s *Scheduler) less(i, j int) bool {
+	a, b := s.queue[i], s.queue[j]
+	if a.when != b.when {
+		return a.when < b.when
+	}
+	return a.kind < b.kind
+}
+
+func (s *Scheduler) swap(i, j int) {
+	s.queue[i], s.queue[j] = s.queue[j], s.queue[i]
+	s.queue[i].index, s.queue[j].index = i, j
+}
+
+func (s *Scheduler) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !s.less(i, parent) {
+			break
+		}
+		s.swap(i, parent)
+		i = parent
+	}
+}
+
+func (s *Scheduler) down(i int) {
+	n := len(s.queue)
+	for {
+		min, l, r := i, 2*i+1, 2*i+2
+		if l < n && s.less(l, min) {
+			min = l
+		}
+		if r < n && s.less(r, min) {
+			min = r
+		}
+		if min == i {
+			return
+		}
+		s.swap(i, min)
+		i = min
+	}
+}
+
+// schedule event ${kind} ${after} cycles later. scheduled event is overwritten.
+func (s *Scheduler) schedule(kind int, after int) {
+	s.scheduleAt(kind, s.now+uint64(after))
+}
+
+func (s *Scheduler) scheduleAt(kind int, when uint64) {
+	e := &s.events[kind]
+	e.when = when
+	if e.index >= 0 {
+		s.up(e.index)
+		s.down(e.index)
+		return
+	}
+	e.index = len(s.queue)
+	s.queue = append(s.queue, e)
+	s.up(e.index)
+}
+
+func (s *Scheduler) cancel(kind int) {
+	e := &s.events[kind]
+	if e.index < 0 {
+		return
+	}
+	i, last := e.index, len(s.queue)-1
+	if i != last {
+		s.swap(i, last)
+	}
+	s.queue = s.queue[:last]
+	e.index = -1
+	if i != last {
+		s.up(i)
+		s.down(i)
+	}
+}
+
+func (s *Scheduler) scheduled(kind int) bool {
+	return s.events[kind].index >= 0
+}
+
+// until returns cycles until the next event
+func (s *Scheduler) until() int {
+	if len(s.queue) == 0 || s.queue[0].when <= s.now {
+		return 1
+	}
+	return int(s.queue[0].when - s.now)
+}
+
+// pop the next event which occurs by ${limit} and move the clock to it
+func (s *Scheduler) pop(limit uint64) (kind int, ok bool) {
+	if len(s.queue) == 0 || s.queue[0].when > limit {
+		return 0, false
+	}
+	e := s.queue[0]
+	s.cancel(e.kind)
+	if e.when > s.now {
+		s.now = e.when
+	}
+	return e.kind, true
+}
+
+func (cpu *CPU) handleEvent(kind int) {
+	switch kind {
+	case eventTimerWrite:
+		cpu.timerWriteEvent()
+	case eventIMESwitch:
+		cpu.Reg.IME = cpu.IMESwitch.Value
+		cpu.IMESwitch.Working = false
+	case eventSerialBit:
+		cpu.serialBitEvent()
+	case eventTimerTick:
+		cpu.timerTickEvent()
+	case eventTIMAReload:
+		cpu.timaReloadEvent()
+	case eventTIMAIncrement:
+		cpu.incrementTIMA()
+	case eventOAMDMA:
+		cpu.oamDMAEvent()
+	case eventAPUFrame:
+		cpu.apuFrameEvent()
+	case eventPPU:
+		cpu.ppuEvent()
+	}
+}
@@ -0,0 +1,169 @@
+package gbc
+
+import (
+	"fmt"
+	"os"
+
+	"gbc/pkg/serial"
+)
+
+// Serial clock is derived from system counter. 8192Hz, or 262144Hz in CGB fast mode (M-cycles per bit)
+const (
+	serialPeriod     = 128
+	serialFastPeriod = 4
+)
+
+// Version - emulator version sent in link cable handshake
+var Version = "Develop"
+
+// linkPort - link port seen from serial device
+type linkPort struct {
+	cpu *CPU
+}
+
+// Receive starts transfer with external clock. The bits are shifted at the pace of the master's clock, then serial interrupt is requested.
+func (p linkPort) Receive(in byte, fast bool) (byte, bool) {
+	cpu := p.cpu
+	if !cpu.Serial.WaitingExternal() {
+		return 0, false
+	}
+	out := cpu.Serial.Receive(in, fast)
+	cpu.scheduler.cancel(eventSerialBit)
+	cpu.scheduleSerialBit()
+	return out, true
+}
+
+// Waiting returns true if SC is 0x80
+func (p linkPort) Waiting() bool {
+	return p.cpu.Serial.WaitingExternal()
+}
+
+// Reply is called in serialBitEvent which polls the device, so the next bit is scheduled there
+func (p linkPort) Reply(in byte) {
+	p.cpu.Serial.Reply(in)
+}
+
+// Pending returns true while the transfer with internal clock waits for the reply
+func (p linkPort) Pending() bool {
+	return p.cpu.Serial.Pending()
+}
+
+// initSerial connects the device in config. [network] network = true is the same as device = "tcp".
+func (cpu *CPU) initSerial() {
+	name := cpu.Config.Serial.Device
+	if cpu.Config.Network.Network {
+		name = "tcp"
+	}
+	if err := cpu.SetSerialDevice(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Serial Error: %s\n", err)
+	}
+}
+
+// SetSerialDevice connects built-in device (none, loopback, stdout, printer, tcp, relay, dmg07) to link port
+// The previous device is disconnected first, so the new one can listen on the same port.
+func (cpu *CPU) SetSerialDevice(name string) error {
+	cpu.ConnectSerial(serial.Disconnected{})
+	network := cpu.Config.Network
+	device, err := serial.New(name, serial.Options{
+		Your: network.Your, Peer: network.Peer,
+		Relay: network.Relay, Room: network.Room,
+		PrintDir: cpu.Config.Serial.PrintDir,
+		Hello:    serial.Hello{ROMHash: cpu.romHash, Version: Version},
+	})
+	if err != nil {
+		return err
+	}
+	cpu.ConnectSerial(device)
+	return nil
+}
+
+// ConnectSerial connects ${device} to link port. The device which carries infrared signal (link cable, tcp) also faces IR port.
+func (cpu *CPU) ConnectSerial(device serial.SerialDevice) {
+	cpu.Serial.Connect(device, linkPort{cpu})
+	ir, _ := device.(serial.IR)
+	cpu.Infrared.Connect(ir)
+}
+
+// serialBitPeriod returns M-cycles per bit of the clock which drives the transfer
+func (cpu *CPU) serialBitPeriod() uint64 {
+	if cpu.Serial.Fast() {
+		return serialFastPeriod
+	}
+	return serialPeriod
+}
+
+// serialPollPeriod returns interval to poll device while waiting for external clock.
+// On CGB, the peer may send 1 byte per 8 bits of high speed clock.
+func (cpu *CPU) serialPollPeriod() int {
+	if cpu.Cartridge.IsCGB {
+		return serialFastPeriod * 8
+	}
+	return serialPeriod
+}
+
+// writeSC starts transfer with internal clock.
+// With external clock, the device is polled until it drives the clock.
+func (cpu *CPU) writeSC(value byte) {
+	cpu.Serial.WriteSC(value)
+	cpu.scheduler.cancel(eventSerialBit)
+	switch value & 0x81 {
+	case 0x81:
+		cpu.Serial.Start(cpu.Cartridge.IsCGB && value&0x02 != 0)
+		cpu.scheduleSerialBit()
+	case 0x80:
+		cpu.scheduler.schedule(eventSerialBit, cpu.serialPollPeriod())
+	}
+}
+
+// scheduleSerialBit schedules the next falling edge of serial clock
+func (cpu *CPU) scheduleSerialBit() {
+	period := cpu.serialBitPeriod()
+	elapsed := cpu.scheduler.now - cpu.Cycle.sys
+	cpu.scheduler.scheduleAt(eventSerialBit, cpu.Cycle.sys+(elapsed/period+1)*period)
+}
+
+// serialBitEvent shifts 1 bit, or polls the device while waiting for external clock or the reply to the pending transfer
+func (cpu *CPU) serialBitEvent() {
+	switch {
+	case cpu.Serial.WaitingExternal():
+		cpu.scheduler.schedule(eventSerialBit, cpu.serialPollPeriod())
+		cpu.Serial.Device().Poll() // Receive cancels the next poll
+		return
+	case cpu.Serial.Pending():
+		if !cpu.Serial.Wait(cpu.scheduler.now) {
+			cpu.scheduler.schedule(eventSerialBit, cpu.serialPollPeriod())
+			return
+		}
+	}
+
+	if cpu.Serial.Shift(cpu.scheduler.now) {
+		cpu.setSerialFlag(true)
+		return
+	}
+	if cpu.Serial.Pending() { // the game goes on while the device waits for the reply
+		cpu.scheduler.schedule(eventSerialBit, cpu.serialPollPeriod())
+		return
+	}
+	cpu.scheduleSerialBit()
+}
+
+func (cpu *CPU) readSC() byte {
+	if cpu.Cartridge.IsCGB {
+		return cpu.Serial.ReadSC() | 0x7c
+	}
+	return cpu.Serial.ReadSC() | 0x7e
+}
+
+// readRP returns infrared port. DMG doesn't have it.
+func (cpu *CPU) readRP() byte {
+	if !cpu.Cartridge.IsCGB {
+		return 0xff
+	}
+	return cpu.Infrared.ReadRP(cpu.scheduler.now)
+}
+
+func (cpu *CPU) writeRP(value byte) {
+	if cpu.Cartridge.IsCGB {
+		cpu.Infrared.WriteRP(value, cpu.scheduler.now)
+	}
+}
@@ -0,0 +1,83 @@
+package gbc
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gbc/pkg/apu"
+)
+
+// initAudio starts playback. Emulation keeps running with NullSink if audio device is unavailable.
+func (cpu *CPU) initAudio() {
+	latency := time.Duration(cpu.Config.Audio.Latency) * time.Millisecond
+	if latency <= 0 {
+		latency = 50 * time.Millisecond
+	}
+	sink, err := apu.NewOtoSink(cpu.Sound.SampleRate(), latency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to start audio: %s\n", err)
+		cpu.Sound.AddSink(apu.NullSink{})
+		return
+	}
+	cpu.Sound.AddSink(sink)
+}
+
+// RecordAudio records sound output into .wav file
+func (cpu *CPU) RecordAudio(path string) error {
+	sink, err := apu.NewWAVSink(path, cpu.Sound.SampleRate())
+	if err != nil {
+		return err
+	}
+	cpu.Sound.AddSink(sink)
+	return nil
+}
+
+// RecordStems records each channel into ${prefix}_ch1.wav ~ ${prefix}_ch4.wav
+func (cpu *CPU) RecordStems(prefix string) error {
+	for ch := 1; ch <= 4; ch++ {
+		sink, err := apu.NewWAVSink(fmt.Sprintf("%s_ch%d.wav", prefix, ch), cpu.Sound.SampleRate())
+		if err != nil {
+			return err
+		}
+		cpu.Sound.AddStem(ch, sink)
+	}
+	return nil
+}
+
+// RecordMIDI records note events into .mid file
+func (cpu *CPU) RecordMIDI(path string) error {
+	return cpu.Sound.RecordMIDI(path)
+}
+
+// APU frame sequencer is clocked by falling edge of DIV bit4 (bit5 in double speed mode)
+func (cpu *CPU) frameSequencerPeriod() uint64 {
+	return 2048 * uint64(cpu.boost)
+}
+
+// syncSound runs APU until now
+func (cpu *CPU) syncSound() {
+	now := cpu.scheduler.now
+	if now > cpu.soundAt {
+		cpu.Sound.Step(int(now-cpu.soundAt) * 4 / cpu.boost)
+	}
+	cpu.soundAt = now
+}
+
+func (cpu *CPU) scheduleFrameSequencer() {
+	period := cpu.frameSequencerPeriod()
+	elapsed := cpu.scheduler.now - cpu.Cycle.sys
+	cpu.scheduler.scheduleAt(eventAPUFrame, cpu.Cycle.sys+(elapsed/period+1)*period)
+}
+
+func (cpu *CPU) apuFrameEvent() {
+	cpu.syncSound()
+	cpu.Sound.FrameSequencer()
+	cpu.scheduleFrameSequencer()
+}
+
+// frameSequencerBit returns whether DIV bit which clocks frame sequencer is set at cycle ${t}
+func (cpu *CPU) frameSequencerBit(t uint64) bool {
+	period := cpu.frameSequencerPeriod()
+	return (t-cpu.Cycle.sys)%period >= period/2
+}
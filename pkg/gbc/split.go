@@ -0,0 +1,90 @@
+package gbc
+
+import (
+	"gbc/pkg/serial"
+
+	ebiten "github.com/hajimehoshi/ebiten/v2"
+)
+
+// Split - two instances side by side in one window. Their link ports are wired together.
+type Split struct {
+	players [2]*CPU
+	running [2]bool // frame is in progress
+	screens [2]*ebiten.Image
+}
+
+// NewSplit links player 1 and player 2 which are initialized with Player 1 and 2
+func NewSplit(p1, p2 *CPU) *Split {
+	a, b := serial.NewLink()
+	p1.ConnectSerial(a)
+	p2.ConnectSerial(b)
+	return &Split{players: [2]*CPU{p1, p2}}
+}
+
+// Update emulates at least 1 frame of both players.
+// The player which is behind in normal speed cycles always runs next, so they never drift apart by more than 1 instruction
+// even if only one of them is in CGB double speed mode.
+// Frame lengths can differ (e.g. LCD is off), so the player which is still behind after its frame starts the next one.
+func (s *Split) Update() error {
+	var finished, paused [2]bool
+	for !(finished[0] || paused[0]) || !(finished[1] || paused[1]) {
+		// player in STOP mode doesn't catch up with the other
+		idle := [2]bool{}
+		for j, cpu := range s.players {
+			idle[j] = paused[j] || (finished[j] && cpu.stopped)
+		}
+		i := 0
+		if idle[0] || (!idle[1] && s.players[1].normalCycles() < s.players[0].normalCycles()) {
+			i = 1
+		}
+		cpu := s.players[i]
+		if !s.running[i] {
+			if !cpu.beginFrame() {
+				paused[i] = true
+				continue
+			}
+			s.running[i] = true
+		}
+
+		cpu.step()
+		if cpu.frame.done {
+			cpu.endFrame()
+			s.running[i], finished[i] = false, true
+			s.present(i)
+		}
+	}
+	return nil
+}
+
+// present copies the completed frame of player ${i} to the window
+func (s *Split) present(i int) {
+	display := s.players[i].screen()
+	w, h := display.Bounds().Dx(), display.Bounds().Dy()
+	if s.screens[i] == nil || s.screens[i].Bounds().Dx() != w {
+		s.screens[i] = ebiten.NewImage(w, h)
+	}
+	s.screens[i].ReplacePixels(display.Pix)
+}
+
+func (s *Split) Draw(screen *ebiten.Image) {
+	for i, img := range s.screens {
+		if img == nil {
+			continue
+		}
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Translate(float64(i*img.Bounds().Dx()), 0)
+		screen.DrawImage(img, op)
+	}
+}
+
+func (s *Split) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+	w, h := s.players[0].Layout(outsideWidth, outsideHeight)
+	return w * 2, h
+}
+
+// Exit both players
+func (s *Split) Exit() {
+	for _, cpu := range s.players {
+		cpu.Exit()
+	}
+}
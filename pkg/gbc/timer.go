@@ -3,17 +3,17 @@ package gbc
 import "gbc/pkg/util"
 
 type Cycle struct {
-	tac      int // use in normal timer
-	div      int // use in div timer
-	scanline int // use in scanline counter
-	serial   int
-	sys      uint16 // 16 bit system counter. ref: https://gbdev.io/pandocs/Timer_Obscure_Behaviour.html
+	tac     int    // use in normal timer
+	tacAt   uint64 // cycle when tac was updated last
+	sys     uint64 // cycle when 16 bit system counter was reset. ref: https://gbdev.io/pandocs/Timer_Obscure_Behaviour.html
+	divBase byte   // DIV value when system counter was reset
 }
 
 type TIMAReload struct {
 	flag  bool
 	value byte
-	after bool // ref: [B] in https://gbdev.io/pandocs/#timer-overflow-behaviour
+	after bool   // ref: [B] in https://gbdev.io/pandocs/#timer-overflow-behaviour
+	at    uint64 // cycle when TIMA was reloaded
 }
 
 type Timer struct {
@@ -34,131 +34,177 @@ type OAMDMA struct {
 
 func (cpu *CPU) setTimerFlag() {
 	cpu.setIO(IFIO, cpu.fetchIO(IFIO)|0x04)
-	cpu.halt = false
 }
 
 func (cpu *CPU) clearTimerFlag() {
 	cpu.setIO(IFIO, cpu.fetchIO(IFIO)&0xfb)
 }
 
+// timer advances ${cycle} M-cycles and handles events which occur in the meantime
 func (cpu *CPU) timer(cycle int) {
-	for i := 0; i < cycle; i++ {
-		cpu.tick()
+	s := &cpu.scheduler
+	target := s.now + uint64(cycle)
+	for {
+		kind, ok := s.pop(target)
+		if !ok {
+			break
+		}
+		cpu.handleEvent(kind)
 	}
-	cpu.Sound.Buffer(4*cycle, cpu.boost)
+	s.now = target
+	cpu.syncSound()
 }
 
 // 0: 4096Hz (1024/4 cycle), 1: 262144Hz (16/4 cycle), 2: 65536Hz (64/4 cycle), 3: 16384Hz (256/4 cycle)
 var clocks = [4]int{1024 / 4, 16 / 4, 64 / 4, 256 / 4}
 
-func (cpu *CPU) tick() {
+// sysCounter returns 16 bit system counter at cycle ${t}
+func (cpu *CPU) sysCounter(t uint64) uint16 {
+	return uint16(t - cpu.Cycle.sys)
+}
+
+func (cpu *CPU) fetchDIV() byte {
+	return cpu.Cycle.divBase + byte((cpu.scheduler.now-cpu.Cycle.sys)/64)
+}
+
+// tacCounter returns normal timer counter at cycle ${t}
+func (cpu *CPU) tacCounter(t uint64) int {
+	if util.Bit(cpu.RAM[TACIO], 2) {
+		return cpu.Cycle.tac + int(int64(t)-int64(cpu.Cycle.tacAt))
+	}
+	return cpu.Cycle.tac
+}
+
+func (cpu *CPU) syncTAC() {
+	now := cpu.scheduler.now
+	cpu.Cycle.tac, cpu.Cycle.tacAt = cpu.tacCounter(now), now
+}
+
+// scheduleTimerTick schedules the cycle when normal timer counter reaches TAC clock
+func (cpu *CPU) scheduleTimerTick() {
 	tac := cpu.RAM[TACIO]
+	if !util.Bit(tac, 2) {
+		cpu.scheduler.cancel(eventTimerTick)
+		return
+	}
+
+	after := clocks[tac&0b11] - cpu.tacCounter(cpu.scheduler.now)
+	if after < 1 {
+		after = 1
+	}
+	cpu.scheduler.schedule(eventTimerTick, after)
+}
+
+// DIV or TAC write takes effect in the next cycle
+func (cpu *CPU) timerWriteEvent() {
 	tickFlag := false
 
 	if cpu.Timer.ResetAll {
 		cpu.Timer.ResetAll = false
 		tickFlag = cpu.resetTimer()
 	}
-	if cpu.Timer.TAC.Change && !tickFlag {
-		cpu.Timer.TAC.Change = false
-		oldTAC, newTAC := cpu.Timer.TAC.Old, cpu.RAM[TACIO]
-		oldClock, newClock := uint16(clocks[oldTAC&0b11]), uint16(clocks[newTAC&0b11])
-		oldEnable, newEnable := oldTAC&0b100 > 0, newTAC&0b100 > 0
-		if oldEnable {
-			if newEnable {
-				tickFlag = cpu.Cycle.sys&(oldClock/2) > 0
-			} else {
-				tickFlag = cpu.Cycle.sys&(oldClock/2) > 0 && cpu.Cycle.sys&(newClock/2) == 0
+	if cpu.Timer.TAC.Change {
+		if tickFlag {
+			cpu.scheduler.schedule(eventTimerWrite, 1)
+		} else {
+			cpu.Timer.TAC.Change = false
+			oldTAC, newTAC := cpu.Timer.TAC.Old, cpu.RAM[TACIO]
+			oldClock, newClock := uint16(clocks[oldTAC&0b11]), uint16(clocks[newTAC&0b11])
+			oldEnable, newEnable := oldTAC&0b100 > 0, newTAC&0b100 > 0
+			sys := cpu.sysCounter(cpu.scheduler.now - 1)
+			if oldEnable {
+				if newEnable {
+					tickFlag = sys&(oldClock/2) > 0
+				} else {
+					tickFlag = sys&(oldClock/2) > 0 && sys&(newClock/2) == 0
+				}
 			}
 		}
 	}
 
-	// lag occurs in di, ei
-	if cpu.IMESwitch.Working {
-		cpu.IMESwitch.Count--
-		if cpu.IMESwitch.Count == 0 {
-			cpu.Reg.IME = cpu.IMESwitch.Value
-			cpu.IMESwitch.Working = false
-		}
+	if tickFlag {
+		cpu.scheduler.schedule(eventTIMAIncrement, 0)
 	}
+}
 
-	// clock management in serial communication
-	if cpu.Config.Network.Network && cpu.Serial.TransferFlag > 0 {
-		cpu.Cycle.serial++
-		if cpu.Cycle.serial > 128*8 {
-			cpu.Serial.TransferFlag = 0
-			close(cpu.serialTick)
-			cpu.Cycle.serial = 0
-			cpu.serialTick = make(chan int)
-		}
-	} else {
-		cpu.Cycle.serial = 0
-	}
+func (cpu *CPU) timerTickEvent() {
+	now := cpu.scheduler.now
+	cpu.Cycle.tac, cpu.Cycle.tacAt = cpu.tacCounter(now)-clocks[cpu.RAM[TACIO]&0b11], now
+	cpu.scheduler.schedule(eventTIMAIncrement, 0)
+	cpu.scheduleTimerTick()
+}
 
-	cpu.Cycle.scanline++
-	cpu.Cycle.sys++ // 16 bit system counter
-	cpu.Cycle.div++
-	if cpu.Cycle.div >= 64 {
-		cpu.RAM[DIVIO]++
-		cpu.Cycle.div -= 64
-	}
+func (cpu *CPU) timaReloadEvent() {
+	cpu.TIMAReload.flag = false
+	cpu.RAM[TIMAIO] = cpu.TIMAReload.value
+	cpu.TIMAReload.after, cpu.TIMAReload.at = true, cpu.scheduler.now
+	cpu.setTimerFlag() // ref: https://gbdev.io/pandocs/#timer-overflow-behaviour
+}
 
-	if util.Bit(tac, 2) {
-		cpu.Cycle.tac++
-		if cpu.Cycle.tac >= clocks[tac&0b11] {
-			cpu.Cycle.tac -= clocks[tac&0b11]
-			tickFlag = true
+// timaReloaded returns true in the cycle TIMA is reloaded from TMA
+func (cpu *CPU) timaReloaded() bool {
+	return cpu.TIMAReload.after && cpu.TIMAReload.at == cpu.scheduler.now
+}
+
+func (cpu *CPU) incrementTIMA() {
+	TIMABefore := cpu.RAM[TIMAIO]
+	TIMAAfter := TIMABefore + 1
+	if TIMAAfter < TIMABefore { // overflow occurs
+		cpu.TIMAReload = TIMAReload{
+			flag:  true,
+			value: uint8(cpu.RAM[TMAIO]),
+			after: false,
 		}
+		cpu.RAM[TIMAIO] = 0
+		cpu.scheduler.schedule(eventTIMAReload, 1)
+	} else {
+		cpu.RAM[TIMAIO] = TIMAAfter
 	}
+}
+
+// during OAM DMA, CPU can access only HRAM
+func (cpu *CPU) oamDMABusy() bool {
+	return cpu.OAMDMA.ptr > 0 && cpu.OAMDMA.ptr <= 160
+}
 
-	cpu.TIMAReload.after = false
-	if cpu.TIMAReload.flag {
-		cpu.TIMAReload.flag = false
-		cpu.RAM[TIMAIO] = cpu.TIMAReload.value
-		cpu.TIMAReload.after = true
-		cpu.setTimerFlag() // ref: https://gbdev.io/pandocs/#timer-overflow-behaviour
+func (cpu *CPU) oamDMAEvent() {
+	if cpu.OAMDMA.ptr == 160 {
+		cpu.RAM[0xfe00+uint16(cpu.OAMDMA.ptr)-1] = cpu.FetchMemory8(cpu.OAMDMA.start + uint16(cpu.OAMDMA.ptr) - 1)
+		cpu.RAM[OAM] = 0xff
+	} else if cpu.OAMDMA.ptr < 160 {
+		cpu.RAM[0xfe00+uint16(cpu.OAMDMA.ptr)-1] = cpu.FetchMemory8(cpu.OAMDMA.start + uint16(cpu.OAMDMA.ptr) - 1)
 	}
 
-	if tickFlag {
-		TIMABefore := cpu.RAM[TIMAIO]
-		TIMAAfter := TIMABefore + 1
-		if TIMAAfter < TIMABefore { // overflow occurs
-			cpu.TIMAReload = TIMAReload{
-				flag:  true,
-				value: uint8(cpu.RAM[TMAIO]),
-				after: false,
-			}
-			cpu.RAM[TIMAIO] = 0
-		} else {
-			cpu.RAM[TIMAIO] = TIMAAfter
+	cpu.OAMDMA.ptr--          // increment OAMDMA count
+	if cpu.OAMDMA.reptr > 0 { // if next OAM is requested, increment that one too
+		cpu.OAMDMA.reptr--
+		if cpu.OAMDMA.reptr == 160 {
+			cpu.OAMDMA.start = cpu.OAMDMA.restart
+			cpu.OAMDMA.ptr, cpu.OAMDMA.reptr = 160, 0
 		}
 	}
 
-	// OAMDMA
 	if cpu.OAMDMA.ptr > 0 {
-		if cpu.OAMDMA.ptr == 160 {
-			cpu.RAM[0xfe00+uint16(cpu.OAMDMA.ptr)-1] = cpu.FetchMemory8(cpu.OAMDMA.start + uint16(cpu.OAMDMA.ptr) - 1)
-			cpu.RAM[OAM] = 0xff
-		} else if cpu.OAMDMA.ptr < 160 {
-			cpu.RAM[0xfe00+uint16(cpu.OAMDMA.ptr)-1] = cpu.FetchMemory8(cpu.OAMDMA.start + uint16(cpu.OAMDMA.ptr) - 1)
-		}
-
-		cpu.OAMDMA.ptr--          // increment OAMDMA count
-		if cpu.OAMDMA.reptr > 0 { // if next OAM is requested, increment that one too
-			cpu.OAMDMA.reptr--
-			if cpu.OAMDMA.reptr == 160 {
-				cpu.OAMDMA.start = cpu.OAMDMA.restart
-				cpu.OAMDMA.ptr, cpu.OAMDMA.reptr = 160, 0
-			}
-		}
+		cpu.scheduler.schedule(eventOAMDMA, 1)
 	}
 }
 
 func (cpu *CPU) resetTimer() bool {
-	cpu.Cycle.sys, cpu.Cycle.div, cpu.RAM[DIVIO] = 0, 0, 0
-
-	old := cpu.Cycle.tac
-	cpu.Cycle.tac = 0
+	now := cpu.scheduler.now
+	old := cpu.tacCounter(now - 1)
+	frameEdge := cpu.frameSequencerBit(now - 1)
+	cpu.Cycle.sys, cpu.Cycle.divBase = now-1, 0
+	cpu.Cycle.tac, cpu.Cycle.tacAt = 0, now-1
+	cpu.scheduleTimerTick()
+
+	if frameEdge { // falling edge of DIV bit clocks frame sequencer
+		cpu.syncSound()
+		cpu.Sound.FrameSequencer()
+	}
+	cpu.scheduleFrameSequencer()
+	if cpu.scheduler.scheduled(eventSerialBit) && cpu.Serial.SC&0x01 != 0 && !cpu.Serial.Pending() { // internal serial clock is also derived from system counter
+		cpu.scheduleSerialBit()
+	}
 
 	tickFlag := false
 	tac := cpu.RAM[TACIO]
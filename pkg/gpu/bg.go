@@ -79,7 +79,7 @@ func (g *GPU) setBGLine(entryX, entryY, lineNumber int, addr uint16, attr byte,
 			R, G, B, isTransparent = g.parseCGBPallete(BGP, palIdx, colorIdx)
 		} else {
 			RGB, isTransparent = g.parsePallete(BGP, colorIdx)
-			R, G, B = colors[RGB][0], colors[RGB][1], colors[RGB][2]
+			R, G, B = g.colors[RGB][0], g.colors[RGB][1], g.colors[RGB][2]
 		}
 		c := color.RGBA{R, G, B, 0xff}
 
@@ -4,6 +4,7 @@ import (
 	"gbc/pkg/util"
 	"image"
 	"image/color"
+	"image/draw"
 
 	hq2x "github.com/pokemium/hq2xgo"
 )
@@ -22,18 +23,15 @@ type GPU struct {
 	Scroll        [2]byte        // Scrollの座標
 	displayColor  [144][160]byte // 160*144の色番号(背景色を記録)
 	Palette       Palette
+	colors        [4][3]uint8 // DMG colors {R, G, B}
 	BGPriorPixels [][5]byte
 	VRAM
-	HBlankDMALength int
 	Debug
 }
 
-var (
-	// colors {R, G, B}
-	colors [4][3]uint8 = [4][3]uint8{
-		{175, 197, 160}, {93, 147, 66}, {22, 63, 48}, {0, 40, 0},
-	}
-)
+var defaultColors = [4][3]uint8{
+	{175, 197, 160}, {93, 147, 66}, {22, 63, 48}, {0, 40, 0},
+}
 
 const (
 	BGP = iota
@@ -44,6 +42,7 @@ const (
 // Init GPU
 func (g *GPU) Init(debug bool) {
 	g.display, g.hq2x = image.NewRGBA(image.Rect(0, 0, 160, 144)), image.NewRGBA(image.Rect(0, 0, 320, 288))
+	g.colors = defaultColors
 	g.Debug.On = debug
 	if debug {
 		g.initTileData()
@@ -59,6 +58,11 @@ func (g *GPU) Display(hq2x bool) *image.RGBA {
 	return g.display
 }
 
+// Blank - fill display with white while LCD doesn't output
+func (g *GPU) Blank() {
+	draw.Draw(g.display, g.display.Bounds(), image.White, image.Point{}, draw.Src)
+}
+
 // GetOriginal - getter for display data in image.RGBA format. Function for debug.
 func (g *GPU) GetOriginal() *image.RGBA {
 	return g.display
@@ -12,11 +12,11 @@ type Palette struct {
 }
 
 // InitPalette init gameboy palette color
-func InitPalette(color0, color1, color2, color3 [3]int) {
-	colors[0] = [3]uint8{uint8(color0[0]), uint8(color0[1]), uint8(color0[2])}
-	colors[1] = [3]uint8{uint8(color1[0]), uint8(color1[1]), uint8(color1[2])}
-	colors[2] = [3]uint8{uint8(color2[0]), uint8(color2[1]), uint8(color2[2])}
-	colors[3] = [3]uint8{uint8(color3[0]), uint8(color3[1]), uint8(color3[2])}
+func (g *GPU) InitPalette(color0, color1, color2, color3 [3]int) {
+	g.colors[0] = [3]uint8{uint8(color0[0]), uint8(color0[1]), uint8(color0[2])}
+	g.colors[1] = [3]uint8{uint8(color1[0]), uint8(color1[1]), uint8(color1[2])}
+	g.colors[2] = [3]uint8{uint8(color2[0]), uint8(color2[1]), uint8(color2[2])}
+	g.colors[3] = [3]uint8{uint8(color3[0]), uint8(color3[1]), uint8(color3[2])}
 }
 
 // BgPalIdx returns bg palette index for CGB
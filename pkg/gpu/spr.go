@@ -54,7 +54,7 @@ func (g *GPU) setSPRLine(entryX, entryY, lineNumber int, addr uint16, tileType i
 
 		// 色番号からRGB値を算出する
 		RGB, isTransparent := g.parsePallete(tileType, colorIdx)
-		R, G, B := colors[RGB][0], colors[RGB][1], colors[RGB][2]
+		R, G, B := g.colors[RGB][0], g.colors[RGB][1], g.colors[RGB][2]
 		if isCGB {
 			palIdx := attr & 0x07 // パレット番号 OBPn
 			R, G, B, isTransparent = g.parseCGBPallete(tileType, palIdx, colorIdx)
@@ -10,6 +10,8 @@ import (
 type Joypad struct {
 	P1                byte
 	Button, Direction [4]bool // start, select, b, a, down, up, left, right
+	Gamepad           ebiten.GamepadID
+	NoKeyboard        bool // ignore keyboard (e.g. player 2 in split screen)
 }
 
 const (
@@ -41,7 +43,7 @@ func (pad *Joypad) Output() byte {
 func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64) (result int) {
 
 	// A
-	if btnA(padA) {
+	if pad.btnA(padA) {
 		pad.Button[0] = true
 		result = Pressed
 	} else {
@@ -49,7 +51,7 @@ func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64
 	}
 
 	// B
-	if btnB(padB) {
+	if pad.btnB(padB) {
 		pad.Button[1] = true
 		result = Pressed
 	} else {
@@ -57,7 +59,7 @@ func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64
 	}
 
 	// select
-	if btnSelect(padSelect) {
+	if pad.btnSelect(padSelect) {
 		pad.Button[2] = true
 		result = Pressed
 	} else {
@@ -65,7 +67,7 @@ func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64
 	}
 
 	// start
-	if btnStart(padStart) {
+	if pad.btnStart(padStart) {
 		pad.Button[3] = true
 		result = Pressed
 	} else {
@@ -73,7 +75,7 @@ func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64
 	}
 
 	// right
-	if keyRight(threshold) {
+	if pad.keyRight(threshold) {
 		pad.Direction[0] = true
 		result = Pressed
 	} else {
@@ -81,7 +83,7 @@ func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64
 	}
 
 	// left
-	if keyLeft(threshold) {
+	if pad.keyLeft(threshold) {
 		pad.Direction[1] = true
 		result = Pressed
 	} else {
@@ -89,7 +91,7 @@ func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64
 	}
 
 	// up
-	if keyUp(threshold) {
+	if pad.keyUp(threshold) {
 		pad.Direction[2] = true
 		result = Pressed
 	} else {
@@ -97,80 +99,85 @@ func (pad *Joypad) Input(padA, padB, padStart, padSelect uint, threshold float64
 	}
 
 	// down
-	if keyDown(threshold) {
+	if pad.keyDown(threshold) {
 		pad.Direction[3] = true
 		result = Pressed
 	} else {
 		pad.Direction[3] = false
 	}
 
-	if btnPause() {
+	if pad.btnPause() {
 		result = Pause
 	}
 
 	return result
 }
 
-func btnA(pad uint) bool {
-	return ebiten.IsGamepadButtonPressed(0, ebiten.GamepadButton(pad)) || ebiten.IsKeyPressed(ebiten.KeyX) || ebiten.IsKeyPressed(ebiten.KeyS)
+func (pad *Joypad) btnA(button uint) bool {
+	return ebiten.IsGamepadButtonPressed(pad.Gamepad, ebiten.GamepadButton(button)) || pad.key(ebiten.KeyX) || pad.key(ebiten.KeyS)
 }
 
-func btnB(pad uint) bool {
-	return ebiten.IsGamepadButtonPressed(0, ebiten.GamepadButton(pad)) || ebiten.IsKeyPressed(ebiten.KeyZ) || ebiten.IsKeyPressed(ebiten.KeyA)
+func (pad *Joypad) btnB(button uint) bool {
+	return ebiten.IsGamepadButtonPressed(pad.Gamepad, ebiten.GamepadButton(button)) || pad.key(ebiten.KeyZ) || pad.key(ebiten.KeyA)
 }
 
-func btnStart(pad uint) bool {
-	return ebiten.IsGamepadButtonPressed(0, ebiten.GamepadButton(pad)) || ebiten.IsKeyPressed(ebiten.KeyEnter)
+func (pad *Joypad) btnStart(button uint) bool {
+	return ebiten.IsGamepadButtonPressed(pad.Gamepad, ebiten.GamepadButton(button)) || pad.key(ebiten.KeyEnter)
 }
 
-func btnSelect(pad uint) bool {
-	return ebiten.IsGamepadButtonPressed(0, ebiten.GamepadButton(pad)) || ebiten.IsKeyPressed(ebiten.KeyShift)
+func (pad *Joypad) btnSelect(button uint) bool {
+	return ebiten.IsGamepadButtonPressed(pad.Gamepad, ebiten.GamepadButton(button)) || pad.key(ebiten.KeyShift)
 }
 
-func keyUp(threshold float64) bool {
-	if threshold > 0 && ebiten.GamepadAxis(0, 1) > threshold {
+func (pad *Joypad) keyUp(threshold float64) bool {
+	if threshold > 0 && ebiten.GamepadAxis(pad.Gamepad, 1) > threshold {
 		return true
 	}
-	if threshold < 0 && ebiten.GamepadAxis(0, 1) < threshold {
+	if threshold < 0 && ebiten.GamepadAxis(pad.Gamepad, 1) < threshold {
 		return true
 	}
 
-	return ebiten.IsKeyPressed(ebiten.KeyUp)
+	return pad.key(ebiten.KeyUp)
 }
 
-func keyDown(threshold float64) bool {
-	if threshold > 0 && -ebiten.GamepadAxis(0, 1) > threshold {
+func (pad *Joypad) keyDown(threshold float64) bool {
+	if threshold > 0 && -ebiten.GamepadAxis(pad.Gamepad, 1) > threshold {
 		return true
 	}
-	if threshold < 0 && -ebiten.GamepadAxis(0, 1) < threshold {
+	if threshold < 0 && -ebiten.GamepadAxis(pad.Gamepad, 1) < threshold {
 		return true
 	}
 
-	return ebiten.IsKeyPressed(ebiten.KeyDown)
+	return pad.key(ebiten.KeyDown)
 }
 
-func keyRight(threshold float64) bool {
-	if threshold > 0 && ebiten.GamepadAxis(0, 0) > threshold {
+func (pad *Joypad) keyRight(threshold float64) bool {
+	if threshold > 0 && ebiten.GamepadAxis(pad.Gamepad, 0) > threshold {
 		return true
 	}
-	if threshold < 0 && ebiten.GamepadAxis(0, 0) > -threshold {
+	if threshold < 0 && ebiten.GamepadAxis(pad.Gamepad, 0) > -threshold {
 		return true
 	}
 
-	return ebiten.IsKeyPressed(ebiten.KeyRight)
+	return pad.key(ebiten.KeyRight)
 }
 
-func keyLeft(threshold float64) bool {
-	if threshold > 0 && ebiten.GamepadAxis(0, 0) < -threshold {
+func (pad *Joypad) keyLeft(threshold float64) bool {
+	if threshold > 0 && ebiten.GamepadAxis(pad.Gamepad, 0) < -threshold {
 		return true
 	}
-	if threshold < 0 && ebiten.GamepadAxis(0, 0) < threshold {
+	if threshold < 0 && ebiten.GamepadAxis(pad.Gamepad, 0) < threshold {
 		return true
 	}
 
-	return ebiten.IsKeyPressed(ebiten.KeyLeft)
+	return pad.key(ebiten.KeyLeft)
 }
 
-func btnPause() bool {
-	return ebiten.IsKeyPressed(ebiten.KeyP)
+func (pad *Joypad) btnPause() bool {
+	return pad.key(ebiten.KeyP)
+}
+
+// key returns whether ${key} is pressed if keyboard is assigned
+func (pad *Joypad) key(key ebiten.Key) bool {
+	return !pad.NoKeyboard && ebiten.IsKeyPressed(key)
 }
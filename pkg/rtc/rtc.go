@@ -1,6 +1,7 @@
 package rtc
 
 import (
+	"encoding/binary"
 	"fmt"
 	"gbc/pkg/util"
 	"time"
@@ -14,28 +15,82 @@ const (
 	DH
 )
 
+const (
+	// Frequency - RTC oscillator (Hz)
+	Frequency = 32768
+	// CyclesPerTick - M-cycles in normal speed per oscillator tick
+	CyclesPerTick = 32
+)
+
+// bits which exist in each register
+var masks = [5]byte{0x3f, 0x3f, 0x1f, 0xff, 0xc1}
+
 // RTC Real Time Clock
+// The clock is advanced by emulated cycles. If SyncHost is true, it also catches up with the real time passed since the game was saved.
 type RTC struct {
 	Enable     bool
 	Mapped     uint
 	Ctr        [5]byte
 	Latched    bool
 	LatchedRTC LatchedRTC
+	SyncHost   bool
+
+	ticks  int // oscillator ticks in the current second
+	cycles int // M-cycles less than 1 tick
 }
 
 // LatchedRTC Latched RTC
 type LatchedRTC struct{ Ctr [5]byte }
 
-// Init rtc clock
-func (rtc *RTC) Init() {
-	rtc.Enable = true
-	for range time.Tick(time.Second) {
-		if rtc.Enable {
-			if rtc.isActive() {
-				rtc.incrementSecond()
-			}
-		}
+// Advance runs the clock for ${cycles} M-cycles in normal speed
+func (rtc *RTC) Advance(cycles int) {
+	if !rtc.isActive() {
+		return
 	}
+	rtc.cycles += cycles
+	rtc.ticks += rtc.cycles / CyclesPerTick
+	rtc.cycles %= CyclesPerTick
+	seconds := rtc.ticks / Frequency
+	rtc.ticks %= Frequency
+	rtc.AdvanceSeconds(seconds)
+}
+
+// AdvanceSeconds runs the clock for ${seconds}
+func (rtc *RTC) AdvanceSeconds(seconds int) {
+	if !rtc.isActive() {
+		return
+	}
+	// registers with out of range value (written by game) count up to the bit width without carry
+	for ; seconds > 0 && !rtc.isValid(); seconds-- {
+		rtc.incrementSecond()
+	}
+	if seconds <= 0 {
+		return
+	}
+
+	total := int(rtc.Ctr[S]) + int(rtc.Ctr[M])*60 + int(rtc.Ctr[H])*3600 + seconds
+	days := rtc.day() + total/86400
+	total %= 86400
+	rtc.Ctr[S], rtc.Ctr[M], rtc.Ctr[H] = byte(total%60), byte(total/60%60), byte(total/3600)
+	if days >= 512 {
+		rtc.Ctr[DH] |= 0x80 // carry
+		days %= 512
+	}
+	rtc.setDay(days)
+}
+
+func (rtc *RTC) isValid() bool {
+	return rtc.Ctr[S] < 60 && rtc.Ctr[M] < 60 && rtc.Ctr[H] < 24
+}
+
+// day returns 9bit day counter
+func (rtc *RTC) day() int {
+	return int(rtc.Ctr[DH]&0x01)<<8 | int(rtc.Ctr[DL])
+}
+
+func (rtc *RTC) setDay(day int) {
+	rtc.Ctr[DL] = byte(day)
+	rtc.Ctr[DH] = rtc.Ctr[DH]&0xfe | byte(day>>8)&0x01
 }
 
 // Read fetch clock register
@@ -53,85 +108,122 @@ func (rtc *RTC) Latch() {
 	}
 }
 
-// Write set clock register
+// Write set clock register. Writing seconds resets the oscillator divider.
 func (rtc *RTC) Write(target, value byte) {
-	rtc.Ctr[target-0x08] = value
+	i := target - 0x08
+	rtc.Ctr[i] = value & masks[i]
+	if i == S {
+		rtc.ticks, rtc.cycles = 0, 0
+	}
 }
 
+// incrementSecond counts 1 second. Each register wraps at its bit width if it's out of range.
 func (rtc *RTC) incrementSecond() {
-	rtc.Ctr[S]++
-	// pass one minute
-	if rtc.Ctr[S] == 60 {
-		rtc.incrementMinute()
+	rtc.Ctr[S] = (rtc.Ctr[S] + 1) & masks[S]
+	if rtc.Ctr[S] != 60 {
+		return
 	}
-}
-
-func (rtc *RTC) incrementMinute() {
-	rtc.Ctr[M]++
 	rtc.Ctr[S] = 0
-	// pass 1 hour
-	if rtc.Ctr[M] == 60 {
-		rtc.incrementHour()
+	rtc.Ctr[M] = (rtc.Ctr[M] + 1) & masks[M]
+	if rtc.Ctr[M] != 60 {
+		return
 	}
-}
-
-func (rtc *RTC) incrementHour() {
-	rtc.Ctr[H]++
 	rtc.Ctr[M] = 0
-	// pass a day
-	if rtc.Ctr[H] == 24 {
-		rtc.incrementDay()
+	rtc.Ctr[H] = (rtc.Ctr[H] + 1) & masks[H]
+	if rtc.Ctr[H] != 24 {
+		return
 	}
+	rtc.Ctr[H] = 0
+	rtc.incrementDay()
 }
 
 func (rtc *RTC) incrementDay() {
-	previousDL := rtc.Ctr[DL]
-	rtc.Ctr[DL]++
-	rtc.Ctr[H] = 0
-	// pass 256 days
-	if rtc.Ctr[DL] < previousDL {
-		rtc.Ctr[DL] = 0
-		if rtc.Ctr[DH]&0x01 == 1 {
-			// msb on day is set
-			rtc.Ctr[DH] |= 0x80
-			rtc.Ctr[DH] &= 0x7f
-		} else {
-			// msb on day is clear
-			rtc.Ctr[DH] |= 0x01
-		}
+	day := rtc.day() + 1
+	if day == 512 {
+		rtc.Ctr[DH] |= 0x80 // carry stays until game clears it
+		day = 0
 	}
+	rtc.setDay(day)
 }
 
 func (rtc *RTC) isActive() bool { return !util.Bit(rtc.Ctr[DH], 6) }
 
-// Dump RTC on common format
-func (rtc *RTC) Dump() []byte {
-	result := make([]byte, 48)
+// RTC footer appended to save data (VBA-M, BGB, SameBoy)
+// registers and latched registers (4 bytes LE each), then UNIX time when saved (8 bytes LE, or 4 bytes in the old 44 bytes format)
+const (
+	FooterSize   = 48
+	FooterSize32 = 44
+)
 
-	result[0], result[4], result[8], result[12], result[16] = rtc.Ctr[S], rtc.Ctr[M], rtc.Ctr[H], rtc.Ctr[DL], rtc.Ctr[DH]
-	result[20], result[24], result[28], result[32], result[36] = rtc.LatchedRTC.Ctr[S], rtc.LatchedRTC.Ctr[M], rtc.LatchedRTC.Ctr[H], rtc.LatchedRTC.Ctr[DL], rtc.LatchedRTC.Ctr[DH]
+// IsFooter returns true if ${size} bytes after SRAM can be RTC footer
+func IsFooter(size int) bool {
+	return size == FooterSize || size == FooterSize32
+}
 
-	now := time.Now().Unix()
-	result[40], result[41], result[42], result[43] = byte(now), byte(now>>8), byte(now>>16), byte(now>>24)
+// Dump RTC on common format
+func (rtc *RTC) Dump() []byte {
+	result := make([]byte, FooterSize)
+	for i := 0; i < 5; i++ {
+		result[i*4] = rtc.Ctr[i]
+		result[20+i*4] = rtc.LatchedRTC.Ctr[i]
+	}
+	binary.LittleEndian.PutUint64(result[40:], uint64(time.Now().Unix()))
 	return result
 }
 
-// Sync RTC data
+// Sync RTC data. The clock catches up with the host time if SyncHost is true.
 func (rtc *RTC) Sync(value []byte) {
-	if len(value) != 44 && len(value) != 48 {
+	if !IsFooter(len(value)) {
 		fmt.Println("invalid RTC format")
 		return
 	}
-	rtc.Ctr = [5]byte{value[0], value[4], value[8], value[12], value[16]}
-	rtc.LatchedRTC.Ctr = [5]byte{value[20], value[24], value[28], value[32], value[36]}
-	lastSaveTime := (int64(value[43]) << 24) | (int64(value[42]) << 16) | (int64(value[41]) << 8) | int64(value[40])
-	delta := int(time.Now().Unix()-lastSaveTime) / 60
-	rtc.advance(delta)
+	for i := 0; i < 5; i++ {
+		rtc.Ctr[i] = value[i*4] & masks[i]
+		rtc.LatchedRTC.Ctr[i] = value[20+i*4] & masks[i]
+	}
+	rtc.ticks, rtc.cycles = 0, 0
+	if !rtc.SyncHost {
+		return
+	}
+
+	if delta := time.Now().Unix() - FooterTime(value).Unix(); delta > 0 { // clock of the host may have gone back
+		rtc.AdvanceSeconds(int(delta))
+	}
+}
+
+// FooterTime returns the time when ${footer} is saved
+func FooterTime(footer []byte) time.Time {
+	if len(footer) == FooterSize {
+		return time.Unix(int64(binary.LittleEndian.Uint64(footer[40:])), 0)
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint32(footer[40:])), 0)
 }
 
-// Advance rtc clock
-func (rtc *RTC) advance(minutes int) {
-	for i := 0; i < minutes; i++ {
-		rtc.incrementMinute()
+// LooksLikeFooter returns false if ${footer} is padding or other data.
+// Each register is stored in 4 bytes without the bits which don't exist, and the time when saved is set.
+// The upper half of 64bit time is 0 until 2106, so 44 bytes footer after 4 bytes of padding isn't taken as 48 bytes one.
+func LooksLikeFooter(footer []byte) bool {
+	if !IsFooter(len(footer)) {
+		return false
+	}
+	for i := 0; i < 10; i++ {
+		if footer[i*4]&^masks[i%5] != 0 || footer[i*4+1] != 0 || footer[i*4+2] != 0 || footer[i*4+3] != 0 {
+			return false
+		}
+	}
+	if len(footer) == FooterSize && binary.LittleEndian.Uint32(footer[44:]) != 0 {
+		return false
+	}
+	return FooterTime(footer).Unix() > 0
+}
+
+func (rtc *RTC) String() string {
+	s := fmt.Sprintf("day %d %02d:%02d:%02d", rtc.day(), rtc.Ctr[H], rtc.Ctr[M], rtc.Ctr[S])
+	if rtc.Ctr[DH]&0x80 != 0 {
+		s += " (day counter overflowed)"
+	}
+	if !rtc.isActive() {
+		s += " (halted)"
 	}
+	return s
 }
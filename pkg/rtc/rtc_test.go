@@ -0,0 +1,133 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// footer returns RTC footer of ${size} bytes with registers ${ctr}, latched registers ${latched} and UNIX time ${saved}
+func footer(size int, ctr, latched [5]byte, saved int64) []byte {
+	data := make([]byte, size)
+	for i := 0; i < 5; i++ {
+		data[i*4] = ctr[i]
+		data[20+i*4] = latched[i]
+	}
+	if size == FooterSize {
+		binary.LittleEndian.PutUint64(data[40:], uint64(saved))
+	} else {
+		binary.LittleEndian.PutUint32(data[40:], uint32(saved))
+	}
+	return data
+}
+
+// seconds returns the time of registers in seconds
+func seconds(ctr [5]byte) int {
+	day := int(ctr[DH]&0x01)<<8 | int(ctr[DL])
+	return day*86400 + int(ctr[H])*3600 + int(ctr[M])*60 + int(ctr[S])
+}
+
+func TestSync(t *testing.T) {
+	ctr, latched := [5]byte{12, 34, 5, 0x10, 0x01}, [5]byte{11, 33, 4, 0x0f, 0x01}
+	saved := time.Now().Add(-time.Hour).Unix()
+	old := footer(FooterSize32, ctr, latched, saved) // old Worldwide wrote 32bit time into 48 bytes footer
+	old = append(old, 0, 0, 0, 0)
+
+	tests := []struct {
+		name   string
+		footer []byte
+	}{
+		{"48 bytes, 64bit time", footer(FooterSize, ctr, latched, saved)},
+		{"44 bytes, 32bit time", footer(FooterSize32, ctr, latched, saved)},
+		{"old 48 bytes, 32bit time", old},
+	}
+	for _, tt := range tests {
+		if !LooksLikeFooter(tt.footer) {
+			t.Errorf("%s: footer isn't detected", tt.name)
+		}
+		if got := FooterTime(tt.footer).Unix(); got != saved {
+			t.Errorf("%s: saved at %d, want %d", tt.name, got, saved)
+		}
+
+		rtc := &RTC{}
+		rtc.Sync(tt.footer)
+		if rtc.Ctr != ctr || rtc.LatchedRTC.Ctr != latched {
+			t.Errorf("%s: registers % x, latched % x, want % x, % x", tt.name, rtc.Ctr, rtc.LatchedRTC.Ctr, ctr, latched)
+		}
+
+		// the clock catches up with the hour since saved
+		rtc = &RTC{SyncHost: true}
+		rtc.Sync(tt.footer)
+		if d := seconds(rtc.Ctr) - seconds(ctr); d < 3600 || d > 3602 {
+			t.Errorf("%s: clock advances %d seconds, want 3600", tt.name, d)
+		}
+		if rtc.LatchedRTC.Ctr != latched {
+			t.Errorf("%s: latched registers are changed by sync", tt.name)
+		}
+	}
+}
+
+func TestDumpSync(t *testing.T) {
+	rtc := &RTC{Ctr: [5]byte{59, 59, 23, 0xff, 0x81}, LatchedRTC: LatchedRTC{Ctr: [5]byte{1, 2, 3, 4, 0}}}
+	data := rtc.Dump()
+	if len(data) != FooterSize || !LooksLikeFooter(data) {
+		t.Fatalf("dump of %d bytes isn't footer", len(data))
+	}
+	if d := time.Since(FooterTime(data)); d < 0 || d > time.Minute {
+		t.Errorf("dump is saved at %s", FooterTime(data))
+	}
+
+	restored := &RTC{}
+	restored.Sync(data)
+	if restored.Ctr != rtc.Ctr || restored.LatchedRTC != rtc.LatchedRTC {
+		t.Errorf("restored % x, % x, want % x, % x", restored.Ctr, restored.LatchedRTC.Ctr, rtc.Ctr, rtc.LatchedRTC.Ctr)
+	}
+}
+
+func TestAdvanceSeconds(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctr     [5]byte
+		seconds int
+		want    [5]byte
+	}{
+		{"second", [5]byte{10, 0, 0, 0, 0}, 1, [5]byte{11, 0, 0, 0, 0}},
+		{"minute carry", [5]byte{59, 59, 0, 0, 0}, 1, [5]byte{0, 0, 1, 0, 0}},
+		{"day carry", [5]byte{59, 59, 23, 0xff, 0x00}, 1, [5]byte{0, 0, 0, 0x00, 0x01}},
+		{"past day 511", [5]byte{59, 59, 23, 0xff, 0x01}, 1, [5]byte{0, 0, 0, 0x00, 0x80}},
+		{"far past day 511", [5]byte{0, 0, 0, 0xff, 0x01}, 3 * 86400, [5]byte{0, 0, 0, 0x02, 0x80}},
+		{"carry is kept", [5]byte{0, 0, 0, 0x00, 0x80}, 86400, [5]byte{0, 0, 0, 0x01, 0x80}},
+		{"halted", [5]byte{10, 0, 0, 0, 0x40}, 100, [5]byte{10, 0, 0, 0, 0x40}},
+		{"second out of range", [5]byte{62, 0, 0, 0, 0}, 1, [5]byte{63, 0, 0, 0, 0}},
+		{"second wraps at bit width", [5]byte{63, 5, 0, 0, 0}, 1, [5]byte{0, 5, 0, 0, 0}},
+		{"minute wraps at bit width", [5]byte{59, 63, 7, 0, 0}, 1, [5]byte{0, 0, 7, 0, 0}},
+		{"hour wraps at bit width", [5]byte{59, 59, 31, 9, 0}, 1, [5]byte{0, 0, 0, 9, 0}},
+		{"back in range", [5]byte{63, 0, 0, 0, 0}, 61, [5]byte{0, 1, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		rtc := &RTC{Ctr: tt.ctr}
+		rtc.AdvanceSeconds(tt.seconds)
+		if rtc.Ctr != tt.want {
+			t.Errorf("%s: % x, want % x", tt.name, rtc.Ctr, tt.want)
+		}
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	rtc := &RTC{}
+	rtc.Advance(Frequency*CyclesPerTick - 1)
+	if rtc.Ctr[S] != 0 {
+		t.Fatal("second passes 1 M-cycle early")
+	}
+	rtc.Advance(1)
+	if rtc.Ctr[S] != 1 {
+		t.Fatal("second doesn't pass after 1 second of M-cycles")
+	}
+
+	rtc.Advance(Frequency * CyclesPerTick / 2)
+	rtc.Write(0x08, 30) // writing seconds resets the divider
+	rtc.Advance(Frequency * CyclesPerTick / 2)
+	if rtc.Ctr[S] != 30 {
+		t.Errorf("second is %d, want 30 because divider is reset", rtc.Ctr[S])
+	}
+}
@@ -0,0 +1,105 @@
+package serial
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Options - settings of network devices
+type Options struct {
+	Your, Peer  string // tcp: listening address and peer address
+	Relay, Room string // relay: relay server address and room code
+	PrintDir    string // printer: directory of printed pages
+	Hello       Hello
+}
+
+// New returns built-in device by name. "link" isn't included because it needs another emulator.
+func New(name string, opts Options) (SerialDevice, error) {
+	switch name {
+	case "", "none":
+		return Disconnected{}, nil
+	case "loopback":
+		return Loopback{}, nil
+	case "stdout":
+		return NewLogger(os.Stdout), nil
+	case "tcp":
+		return NewTCP(opts.Your, opts.Peer, opts.Hello)
+	case "printer":
+		return NewPrinter(opts.PrintDir), nil
+	case "relay":
+		return DialRelay(opts.Relay, opts.Room, opts.Hello)
+	case "dmg07":
+		return NewDMG07Host(opts.Your, opts.Hello)
+	}
+	return nil, fmt.Errorf("unknown serial device: %s", name)
+}
+
+// Disconnected - nothing is connected. 0xff is shifted in.
+type Disconnected struct{}
+
+func (Disconnected) Attach(port Port)                          {}
+func (Disconnected) Exchange(out byte, fast bool) (byte, bool) { return 0xff, true }
+func (Disconnected) Poll()                                     {}
+func (Disconnected) Close() error                              { return nil }
+
+// Loopback - SO is connected to SI. Sent byte comes back.
+type Loopback struct{}
+
+func (Loopback) Attach(port Port)                          {}
+func (Loopback) Exchange(out byte, fast bool) (byte, bool) { return out, true }
+func (Loopback) Poll()                                     {}
+func (Loopback) Close() error                              { return nil }
+
+// Logger writes sent bytes (e.g. test ROM output) to writer
+type Logger struct {
+	w io.Writer
+}
+
+// NewLogger returns logger which writes to ${w}
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+func (l *Logger) Attach(port Port) {}
+func (l *Logger) Poll()            {}
+func (l *Logger) Close() error     { return nil }
+
+func (l *Logger) Exchange(out byte, fast bool) (byte, bool) {
+	l.w.Write([]byte{out})
+	return 0xff, true
+}
+
+// Link - in-process link cable between two emulators running in the same goroutine
+type Link struct {
+	port Port
+	peer *Link
+	led  bool // infrared LED of this side
+}
+
+// NewLink returns both ends of a link cable
+func NewLink() (*Link, *Link) {
+	a, b := &Link{}, &Link{}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (l *Link) Attach(port Port) { l.port = port }
+func (l *Link) Poll()            {}
+func (l *Link) Close() error     { return nil }
+
+// Exchange clocks peer's transfer. Peer which isn't waiting for external clock shifts out 0xff.
+func (l *Link) Exchange(out byte, fast bool) (byte, bool) {
+	if l.peer.port == nil {
+		return 0xff, true
+	}
+	in, ok := l.peer.port.Receive(out, fast)
+	if !ok {
+		return 0xff, true
+	}
+	return in, true
+}
+
+// SetLED and Light carry the infrared signal. Both emulators run in the same timeline, so the level is shared as it is.
+func (l *Link) SetLED(on bool, now uint64) { l.led = on }
+func (l *Link) Light(now uint64) bool      { return l.peer.led }
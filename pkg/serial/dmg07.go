@@ -0,0 +1,316 @@
+package serial
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// DMG-07 Four Player Adapter
+//
+// The adapter drives the clock and every Game Boy waits with external clock.
+//
+// Ping phase: the adapter sends packets of 0xfe and 3 STAT bytes (bit4-7: connected players, bit0-2: player ID).
+// Game Boys answer ACK(0x88), ACK(0x88), RATE and SIZE. Player 1 sends 0xaa instead of ACK to start transmission.
+//
+// Transmission phase: after a packet of 0xcc, each round is 4*SIZE bytes. Each Game Boy sends its SIZE bytes at the head of a round,
+// and the adapter sends the data of all players collected in the previous round. If all players send 0xff, ping phase starts again.
+const (
+	dmg07Ping = iota
+	dmg07Transition
+	dmg07Transmission
+)
+
+const (
+	dmg07Header   = 0xfe
+	dmg07ACK      = 0x88
+	dmg07Start    = 0xaa
+	dmg07Sync     = 0xcc
+	dmg07Patience = 64   // polls to wait for players which aren't ready
+	dmg07Timeout  = 8192 // polls to wait for the reply of remote players
+)
+
+// DMG07 - Four Player Adapter. Player 1 decides RATE and SIZE.
+type DMG07 struct {
+	ports   [4]Port
+	remotes [4]*TCP
+
+	in    [4]byte // bytes received in the current clock
+	ok    [4]bool
+	waits byte // bit n: reply of remote player n+1 hasn't arrived yet
+	polls int  // polls since the byte is sent to remote players
+
+	phase     int
+	pos       int  // byte position in packet
+	connected byte // bit n: player n+1 answers ping
+	acks      [4]int
+	start     bool // player 1 requested transmission
+	rate      byte // RATE of player 1. Bytes are clocked as soon as all players are ready.
+	size      int  // bytes per player in transmission phase
+	recv      [4][]byte
+	send      []byte // data of all players collected in the previous round
+	idle      int
+}
+
+// NewDMG07 returns adapter which has no players
+func NewDMG07() *DMG07 {
+	return &DMG07{size: 1}
+}
+
+// Player returns the end of cable for player ${n}(1-4)
+func (a *DMG07) Player(n int) SerialDevice {
+	return &dmg07End{adapter: a, n: n - 1}
+}
+
+// ConnectRemote connects the Game Boy which uses tcp device over ${t} as player ${n}(1-4)
+func (a *DMG07) ConnectRemote(n int, t *TCP) {
+	a.remotes[n-1] = t
+	t.Attach(remotePort{adapter: a, n: n - 1})
+}
+
+// Connected returns players which answer ping (bit n: player n+1)
+func (a *DMG07) Connected() byte {
+	return a.connected
+}
+
+// poll clocks 1 byte when all players are waiting, or some of them have waited long enough.
+// Remote players get the byte at the same time, and the byte is finished when all of them reply or the replies time out.
+func (a *DMG07) poll() {
+	if a.waits != 0 {
+		for _, t := range a.remotes {
+			if t != nil {
+				t.Poll()
+			}
+		}
+		a.polls++
+		if a.waits != 0 && a.polls < dmg07Timeout {
+			return
+		}
+		a.waits = 0
+		a.finish()
+		return
+	}
+
+	ready, waiting := true, false
+	for _, p := range a.ports {
+		if p == nil {
+			continue
+		}
+		if p.Waiting() {
+			waiting = true
+		} else {
+			ready = false
+		}
+	}
+	if !waiting {
+		return
+	}
+	a.idle++
+	if !ready && a.idle < dmg07Patience {
+		return
+	}
+	a.idle = 0
+	a.clock()
+}
+
+func (a *DMG07) clock() {
+	for n := range a.ports {
+		a.in[n], a.ok[n] = 0, false
+		switch {
+		case a.remotes[n] != nil: // Exchange completes at once only if the cable is disconnected
+			if _, ok := a.remotes[n].Exchange(a.output(n), false); !ok {
+				a.waits |= 1 << n
+			}
+		case a.ports[n] != nil:
+			a.in[n], a.ok[n] = a.ports[n].Receive(a.output(n), false) // the adapter clocks at 8192Hz
+		}
+	}
+	a.polls = 0
+	if a.waits == 0 {
+		a.finish()
+	}
+}
+
+// finish handles the bytes received in the current clock
+func (a *DMG07) finish() {
+	in, ok := a.in, a.ok
+	switch a.phase {
+	case dmg07Ping:
+		a.ping(in, ok)
+	case dmg07Transition:
+		a.pos++
+		if a.pos == 4 {
+			a.phase, a.pos = dmg07Transmission, 0
+			a.send = make([]byte, 4*a.size)
+			for n := range a.recv {
+				a.recv[n] = make([]byte, a.size)
+			}
+		}
+	case dmg07Transmission:
+		a.transmit(in, ok)
+	}
+}
+
+// output returns the byte which is sent to player ${n}(0-3)
+func (a *DMG07) output(n int) byte {
+	switch a.phase {
+	case dmg07Ping:
+		if a.pos == 0 {
+			return dmg07Header
+		}
+		return a.connected<<4 | byte(n+1)
+	case dmg07Transition:
+		return dmg07Sync
+	}
+	return a.send[a.pos]
+}
+
+func (a *DMG07) ping(in [4]byte, ok [4]bool) {
+	for n := range a.ports {
+		if !ok[n] {
+			a.acks[n] = 0
+			continue
+		}
+		switch a.pos {
+		case 0, 1:
+			if in[n] == dmg07ACK {
+				a.acks[n]++
+			}
+			if n == 0 && in[n] == dmg07Start && a.connected&1 != 0 {
+				a.start = true
+			}
+		case 2:
+			if n == 0 && !a.start {
+				a.rate = in[n]
+			}
+		case 3:
+			if n == 0 && !a.start && in[n] >= 1 && in[n] <= 4 {
+				a.size = int(in[n])
+			}
+		}
+	}
+
+	a.pos++
+	if a.pos < 4 {
+		return
+	}
+	a.pos = 0
+	for n := range a.acks {
+		if a.acks[n] == 2 {
+			a.connected |= 1 << n
+		} else if !a.start {
+			a.connected &^= 1 << n
+		}
+		a.acks[n] = 0
+	}
+	if a.start {
+		a.phase, a.start = dmg07Transition, false
+	}
+}
+
+func (a *DMG07) transmit(in [4]byte, ok [4]bool) {
+	if a.pos < a.size {
+		for n := range a.ports {
+			a.recv[n][a.pos] = 0
+			if ok[n] {
+				a.recv[n][a.pos] = in[n]
+			}
+		}
+	}
+
+	a.pos++
+	if a.pos < 4*a.size {
+		return
+	}
+	a.pos = 0
+
+	restart := true
+	for n := range a.recv {
+		connected := a.connected&(1<<n) != 0
+		for i, b := range a.recv[n] {
+			if !connected {
+				b = 0
+			}
+			a.send[n*a.size+i] = b
+			if connected && b != 0xff {
+				restart = false
+			}
+		}
+	}
+	if restart {
+		a.phase = dmg07Ping
+	}
+}
+
+// dmg07End - cable between adapter and a Game Boy in the same process
+type dmg07End struct {
+	adapter *DMG07
+	n       int
+}
+
+func (e *dmg07End) Attach(port Port) { e.adapter.ports[e.n] = port }
+
+// Exchange returns 0xff because the adapter doesn't answer Game Boy which drives the clock
+func (e *dmg07End) Exchange(out byte, fast bool) (byte, bool) { return 0xff, true }
+func (e *dmg07End) Poll()                                     { e.adapter.poll() }
+
+func (e *dmg07End) Close() error {
+	e.adapter.ports[e.n] = nil
+	return nil
+}
+
+// remotePort - adapter side of tcp device for remote player ${n}(0-3). The adapter is master of every transfer.
+type remotePort struct {
+	adapter *DMG07
+	n       int
+}
+
+// Receive refuses the transfer of remote Game Boy which drives the clock
+func (p remotePort) Receive(in byte, fast bool) (byte, bool) { return 0, false }
+func (p remotePort) Waiting() bool                           { return false }
+func (p remotePort) Pending() bool                           { return p.adapter.waits&(1<<p.n) != 0 }
+
+func (p remotePort) Reply(in byte) {
+	if p.Pending() {
+		p.adapter.in[p.n], p.adapter.ok[p.n] = in, true
+		p.adapter.waits &^= 1 << p.n
+	}
+}
+
+// NewDMG07Host returns adapter end for player 1. Player 2-4 connect with tcp device to ${your} and the next 2 ports.
+func NewDMG07Host(your string, hello Hello) (SerialDevice, error) {
+	host, port, err := net.SplitHostPort(your)
+	if err != nil {
+		return nil, err
+	}
+	base, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", port)
+	}
+
+	a := NewDMG07()
+	host1 := &dmg07Host{dmg07End: dmg07End{adapter: a}}
+	for n := 2; n <= 4; n++ {
+		t, err := NewTCP(net.JoinHostPort(host, strconv.Itoa(base+n-2)), "", hello)
+		if err != nil {
+			host1.Close()
+			return nil, err
+		}
+		a.ConnectRemote(n, t)
+		host1.remotes = append(host1.remotes, t)
+	}
+	return host1, nil
+}
+
+// dmg07Host - player 1 which hosts adapter for remote players
+type dmg07Host struct {
+	dmg07End
+	remotes []*TCP
+}
+
+func (h *dmg07Host) Close() error {
+	for _, t := range h.remotes {
+		t.Close()
+	}
+	return h.dmg07End.Close()
+}
@@ -0,0 +1,194 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+const testPacketSize = 2
+
+// fourPlayerGame - game which plays with DMG-07. Player 1 starts transmission when all players are connected.
+type fourPlayerGame struct {
+	testPort
+	id      int // 1-4
+	players byte
+	ping    int // position in ping packet
+	syncs   int
+	sent    int // bytes sent in transmission phase
+	rounds  [][]byte
+}
+
+func newFourPlayerGame(id int) *fourPlayerGame {
+	return &fourPlayerGame{testPort: testPort{sb: dmg07ACK, waiting: true}, id: id}
+}
+
+// data returns the byte which player sends at ${i} of round ${round}
+func (g *fourPlayerGame) data(round, i int) byte {
+	return byte(g.id<<4 | (round*testPacketSize+i)&0x0f)
+}
+
+// update handles the received byte and writes the next byte to SB like interrupt handler
+func (g *fourPlayerGame) update() {
+	if g.waiting || len(g.received) == 0 {
+		return
+	}
+	in := g.received[len(g.received)-1]
+
+	if g.syncs < 4 {
+		switch {
+		case in == dmg07Sync:
+			g.syncs++
+			g.sb = 0
+			if g.syncs == 4 {
+				g.sb = g.data(0, 0)
+			}
+		case in == dmg07Header:
+			g.ping, g.sb = 1, dmg07ACK
+		default:
+			g.players = in >> 4
+			switch g.ping {
+			case 1:
+				g.sb = 0x10 // RATE
+			case 2:
+				g.sb = testPacketSize
+			case 3:
+				g.sb = dmg07ACK
+				if g.id == 1 && g.players == 0x0f {
+					g.sb = dmg07Start
+				}
+			}
+			g.ping++
+		}
+		g.waiting = true
+		return
+	}
+
+	length := 4 * testPacketSize
+	round, i := g.sent/length, g.sent%length
+	if i == 0 {
+		g.rounds = append(g.rounds, nil)
+	}
+	g.rounds[round] = append(g.rounds[round], in)
+	g.sent++
+	round, i = g.sent/length, g.sent%length
+	g.sb = 0
+	if i < testPacketSize {
+		g.sb = g.data(round, i)
+	}
+	g.waiting = true
+}
+
+// check fails if received data isn't the data of all players in the previous round
+func (g *fourPlayerGame) check(t *testing.T, games []*fourPlayerGame) {
+	if len(g.rounds) < 4 {
+		t.Fatalf("player %d: %d rounds are transmitted", g.id, len(g.rounds))
+	}
+	for r := 1; r < len(g.rounds)-1; r++ {
+		for i, in := range g.rounds[r] {
+			want := games[i/testPacketSize].data(r-1, i%testPacketSize)
+			if in != want {
+				t.Fatalf("player %d: round %d byte %d is %02x, want %02x", g.id, r, i, in, want)
+			}
+		}
+	}
+}
+
+func TestDMG07(t *testing.T) {
+	adapter := NewDMG07()
+	games := []*fourPlayerGame{}
+	ends := []SerialDevice{}
+	for id := 1; id <= 4; id++ {
+		g := newFourPlayerGame(id)
+		end := adapter.Player(id)
+		end.Attach(g)
+		games, ends = append(games, g), append(ends, end)
+	}
+
+	for step := 0; step < 10000 && len(games[3].rounds) < 8; step++ {
+		for i, g := range games {
+			// player 3 is slow. The adapter waits for it.
+			if i != 2 || step%3 == 0 {
+				g.update()
+			}
+			ends[i].Poll()
+		}
+	}
+
+	if adapter.Connected() != 0x0f {
+		t.Errorf("connected players: %04b, want 1111", adapter.Connected())
+	}
+	for _, g := range games {
+		g.check(t, games)
+	}
+}
+
+func TestDMG07Remote(t *testing.T) {
+	adapter := NewDMG07()
+	games := []*fourPlayerGame{}
+	for id := 1; id <= 4; id++ {
+		games = append(games, newFourPlayerGame(id))
+	}
+	ends := []SerialDevice{}
+	for id := 1; id <= 3; id++ {
+		end := adapter.Player(id)
+		end.Attach(games[id-1])
+		ends = append(ends, end)
+	}
+
+	// player 4 uses tcp device and the adapter connects to it
+	addrHost, addrRemote := freeAddr(t), freeAddr(t)
+	host, err := NewTCP(addrHost, "", Hello{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	remote, err := NewTCP(addrRemote, addrHost, Hello{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	waitConnected(t, host, remote)
+	adapter.ConnectRemote(4, host)
+
+	remote.Attach(games[3])
+	stop, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			remote.Poll()
+			games[3].update()
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	slowest := time.Duration(0)
+	for len(games[0].rounds) < 6 && time.Now().Before(deadline) {
+		for i, g := range games[:3] {
+			g.update()
+			start := time.Now()
+			ends[i].Poll()
+			if d := time.Since(start); d > slowest {
+				slowest = d
+			}
+		}
+		time.Sleep(50 * time.Microsecond) // emulated time between polls
+	}
+	close(stop)
+	<-finished
+
+	if slowest > 100*time.Millisecond { // network round trip must not stop emulation of local players
+		t.Errorf("poll blocks for %s", slowest)
+	}
+	if adapter.Connected() != 0x0f {
+		t.Errorf("connected players: %04b, want 1111", adapter.Connected())
+	}
+	for _, g := range games[:3] {
+		g.check(t, games)
+	}
+}
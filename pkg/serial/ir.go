@@ -0,0 +1,38 @@
+package serial
+
+// IR - the other side of CGB infrared port. ${now} is emulated time in M-cycles.
+type IR interface {
+	// SetLED is called when this Game Boy turns its LED on or off
+	SetLED(on bool, now uint64)
+	// Light returns true if the other side's LED is on
+	Light(now uint64) bool
+}
+
+// Infrared - CGB infrared communication port (RP: 0xff56)
+// bit0: LED on, bit1: 0 if light is received (read only), bit6-7: 3 enables reading
+type Infrared struct {
+	RP   byte
+	peer IR
+}
+
+// Connect faces the port to ${peer}. nil means nothing is there.
+func (ir *Infrared) Connect(peer IR) {
+	ir.peer = peer
+}
+
+// WriteRP turns LED on/off and enables reading
+func (ir *Infrared) WriteRP(value byte, now uint64) {
+	if led := value & 0x01; led != ir.RP&0x01 && ir.peer != nil {
+		ir.peer.SetLED(led != 0, now)
+	}
+	ir.RP = value & 0xc1
+}
+
+// ReadRP returns RP with the received signal
+func (ir *Infrared) ReadRP(now uint64) byte {
+	value := ir.RP | 0x3e
+	if ir.RP&0xc0 == 0xc0 && ir.peer != nil && ir.peer.Light(now) {
+		value &^= 0x02
+	}
+	return value
+}
@@ -0,0 +1,290 @@
+package serial
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// Game Boy Printer
+//
+// Packet: 0x88 0x33, command, compression, length(2, LE), data, checksum(2, LE), 0x00, 0x00
+// Printer shifts out 0x00 while receiving the packet, then 0x81(alive) and status.
+const (
+	printerINIT   = 0x01
+	printerPRINT  = 0x02
+	printerDATA   = 0x04
+	printerSTATUS = 0x0f
+)
+
+// printer status
+const (
+	statusChecksum = 1 << 0
+	statusPrinting = 1 << 1
+	statusFull     = 1 << 2
+	statusData     = 1 << 3 // unprocessed data
+)
+
+const (
+	printerAlive   = 0x81
+	printerBufSize = 0x280 * 9 // 160x144 pixels
+	printerWidth   = 160
+)
+
+// printer packet state
+const (
+	stateMagic0 = iota
+	stateMagic1
+	stateCommand
+	stateCompression
+	stateLength0
+	stateLength1
+	stateData
+	stateChecksum0
+	stateChecksum1
+	stateAlive
+	stateStatus
+)
+
+// Printer - Game Boy Printer which saves each printed page as PNG
+type Printer struct {
+	dir string
+
+	state       int
+	command     byte
+	compressed  bool
+	length      int
+	data        []byte
+	sum, check  uint16
+	status      byte
+	busy        int    // remaining STATUS packets which report printing
+	buf         []byte // received image data (2bpp tiles)
+	page        []byte // printed strips in gray scale. they are concatenated until the page is fed.
+	pageLines   int
+	printedPage int
+}
+
+// NewPrinter returns printer which saves pages into ${dir}
+func NewPrinter(dir string) *Printer {
+	if dir == "" {
+		dir = "."
+	}
+	return &Printer{dir: dir}
+}
+
+func (p *Printer) Attach(port Port) {}
+func (p *Printer) Poll()            {}
+
+// Close saves the page which isn't fed yet
+func (p *Printer) Close() error {
+	return p.feed()
+}
+
+// Exchange receives 1 byte of packet and returns the byte shifted out at the same time
+func (p *Printer) Exchange(out byte, fast bool) (byte, bool) {
+	return p.shift(out), true
+}
+
+func (p *Printer) shift(out byte) byte {
+	in := byte(0x00)
+	switch p.state {
+	case stateMagic0:
+		if out == 0x88 {
+			p.state = stateMagic1
+		}
+		return in
+	case stateMagic1:
+		p.state = stateMagic0
+		if out == 0x33 {
+			p.state = stateCommand
+		}
+		return in
+	case stateCommand:
+		p.command, p.sum = out, uint16(out)
+		p.state = stateCompression
+		return in
+	case stateCompression:
+		p.compressed = out&0x01 != 0
+		p.state = stateLength0
+	case stateLength0:
+		p.length = int(out)
+		p.state = stateLength1
+	case stateLength1:
+		p.length |= int(out) << 8
+		p.data = p.data[:0]
+		p.state = stateData
+		if p.length == 0 {
+			p.state = stateChecksum0
+		}
+	case stateData:
+		p.data = append(p.data, out)
+		if len(p.data) == p.length {
+			p.state = stateChecksum0
+		}
+	case stateChecksum0:
+		p.check = uint16(out)
+		p.state = stateChecksum1
+		return in
+	case stateChecksum1:
+		p.check |= uint16(out) << 8
+		p.state = stateAlive
+		p.handle()
+		return in
+	case stateAlive:
+		p.state = stateStatus
+		return printerAlive
+	case stateStatus:
+		p.state = stateMagic0
+		return p.status
+	}
+	p.sum += uint16(out)
+	return in
+}
+
+// handle runs the command of received packet
+func (p *Printer) handle() {
+	if p.sum != p.check {
+		p.status |= statusChecksum
+		return
+	}
+	p.status &^= statusChecksum
+
+	switch p.command {
+	case printerINIT:
+		p.buf = p.buf[:0]
+		p.status, p.busy = 0, 0
+	case printerDATA:
+		data := p.data
+		if p.compressed {
+			data = decompress(data)
+		}
+		if len(p.buf)+len(data) > printerBufSize {
+			data = data[:printerBufSize-len(p.buf)]
+		}
+		p.buf = append(p.buf, data...)
+		p.status |= statusData
+		if len(p.buf) == printerBufSize {
+			p.status |= statusFull
+		}
+	case printerPRINT:
+		if len(p.data) < 4 {
+			return
+		}
+		sheets, margins, palette, exposure := p.data[0], p.data[1], p.data[2], p.data[3]
+		for i := 0; i < int(sheets); i++ {
+			p.printStrip(palette, exposure)
+		}
+		p.buf = p.buf[:0]
+		p.status &^= statusData | statusFull
+		p.status |= statusPrinting
+		p.busy = 2
+		if margins&0x0f != 0 { // margin after printing feeds the page
+			if err := p.feed(); err != nil {
+				fmt.Fprintf(os.Stderr, "Printer Error: %s\n", err)
+			}
+		}
+	case printerSTATUS:
+		if p.busy > 0 {
+			p.busy--
+			if p.busy == 0 {
+				p.status &^= statusPrinting
+			}
+		}
+	}
+}
+
+// decompress expands RLE data. 0x80|n: next byte is repeated n+2 times, n: next n+1 bytes are raw.
+func decompress(data []byte) []byte {
+	result := []byte{}
+	for i := 0; i < len(data); {
+		ctrl := data[i]
+		i++
+		if ctrl&0x80 != 0 {
+			if i >= len(data) {
+				break
+			}
+			for n := 0; n < int(ctrl&0x7f)+2; n++ {
+				result = append(result, data[i])
+			}
+			i++
+			continue
+		}
+		n := int(ctrl) + 1
+		if i+n > len(data) {
+			n = len(data) - i
+		}
+		result = append(result, data[i:i+n]...)
+		i += n
+	}
+	return result
+}
+
+// printStrip decodes received tiles and appends them to the page
+// Exposure 0x00-0x7f is -25% ~ +25% darkness.
+func (p *Printer) printStrip(palette, exposure byte) {
+	rows := len(p.buf) / (printerWidth / 8 * 16) // 20 tiles per row
+	darkness := 0.75 + 0.5*float64(exposure&0x7f)/0x7f
+	if palette == 0x00 { // some games send 0x00, which is treated as the default palette
+		palette = 0xe4
+	}
+
+	var shades [4]byte
+	for i := range shades {
+		shade := palette >> (i * 2) & 0x03
+		d := float64(shade) * 85 * darkness
+		if d > 255 {
+			d = 255
+		}
+		shades[i] = 255 - byte(d)
+	}
+
+	strip := make([]byte, rows*8*printerWidth)
+	for row := 0; row < rows; row++ {
+		for tile := 0; tile < printerWidth/8; tile++ {
+			t := p.buf[(row*printerWidth/8+tile)*16:]
+			for y := 0; y < 8; y++ {
+				lo, hi := t[y*2], t[y*2+1]
+				for x := 0; x < 8; x++ {
+					c := (lo>>(7-x))&1 | ((hi>>(7-x))&1)<<1
+					strip[(row*8+y)*printerWidth+tile*8+x] = shades[c]
+				}
+			}
+		}
+	}
+	p.page = append(p.page, strip...)
+	p.pageLines += rows * 8
+}
+
+// feed saves the page as ${dir}/print_NNN.png
+func (p *Printer) feed() error {
+	if p.pageLines == 0 {
+		return nil
+	}
+	img := image.NewGray(image.Rect(0, 0, printerWidth, p.pageLines))
+	copy(img.Pix, p.page)
+	p.page, p.pageLines = p.page[:0], 0
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	var path string
+	for {
+		p.printedPage++
+		path = filepath.Join(p.dir, fmt.Sprintf("print_%03d.png", p.printedPage))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Printer: %s\n", path)
+	return f.Close()
+}
@@ -0,0 +1,96 @@
+package serial
+
+import (
+	"bytes"
+	"testing"
+)
+
+// sendPacket sends packet to printer and returns the last 2 bytes shifted out (alive and status)
+func sendPacket(p *Printer, command byte, compressed bool, data []byte, corrupt bool) (alive, status byte) {
+	compression := byte(0)
+	if compressed {
+		compression = 1
+	}
+	body := append([]byte{command, compression, byte(len(data)), byte(len(data) >> 8)}, data...)
+	sum := uint16(0)
+	for _, b := range body {
+		sum += uint16(b)
+	}
+	if corrupt {
+		sum++
+	}
+
+	packet := append([]byte{0x88, 0x33}, body...)
+	packet = append(packet, byte(sum), byte(sum>>8), 0x00, 0x00)
+	for _, out := range packet {
+		in, _ := p.Exchange(out, false)
+		alive, status = status, in
+	}
+	return alive, status
+}
+
+func TestPrinterPacket(t *testing.T) {
+	tile := bytes.Repeat([]byte{0xff, 0x00}, 8)
+	tests := []struct {
+		name       string
+		command    byte
+		compressed bool
+		data       []byte
+		corrupt    bool
+		status     byte
+		buf        int // bytes in buffer after the packet
+	}{
+		{"init", printerINIT, false, nil, false, 0, 0},
+		{"data", printerDATA, false, tile, false, statusData, 16},
+		{"compressed data", printerDATA, true, []byte{0x80 | 14, 0xff}, false, statusData, 16},
+		{"broken checksum", printerDATA, false, tile, true, statusChecksum, 0},
+		{"status", printerSTATUS, false, nil, false, 0, 0},
+	}
+	for _, tt := range tests {
+		p := NewPrinter(t.TempDir())
+		alive, status := sendPacket(p, tt.command, tt.compressed, tt.data, tt.corrupt)
+		if alive != printerAlive {
+			t.Errorf("%s: printer shifts out %02x instead of alive", tt.name, alive)
+		}
+		if status != tt.status || len(p.buf) != tt.buf { // status is shifted out after the command is handled
+			t.Errorf("%s: status %02x, %d bytes in buffer, want %02x, %d bytes", tt.name, status, len(p.buf), tt.status, tt.buf)
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"raw", []byte{0x02, 0x01, 0x02, 0x03}, []byte{0x01, 0x02, 0x03}},
+		{"repeat", []byte{0x81, 0xaa}, []byte{0xaa, 0xaa, 0xaa}},
+		{"mixed", []byte{0x80, 0x11, 0x00, 0x22, 0x82, 0x33}, []byte{0x11, 0x11, 0x22, 0x33, 0x33, 0x33, 0x33}},
+		{"short raw", []byte{0x03, 0x01}, []byte{0x01}},
+		{"short repeat", []byte{0x01, 0x44, 0x55, 0x85}, []byte{0x44, 0x55}},
+	}
+	for _, tt := range tests {
+		if got := decompress(tt.data); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrinterPalette(t *testing.T) {
+	tile := []byte{0x55, 0x33} // colors 0-3 in the first line
+	tile = append(tile, make([]byte, 14)...)
+	pages := [2][]byte{}
+	for i, palette := range []byte{0x00, 0xe4} {
+		p := NewPrinter(t.TempDir())
+		p.buf = bytes.Repeat(tile, printerWidth/8)
+		p.printStrip(palette, 0x40)
+		pages[i] = p.page
+	}
+	if !bytes.Equal(pages[0], pages[1]) {
+		t.Error("palette 0x00 isn't printed with the default palette 0xe4")
+	}
+	if shades := pages[1][:4]; shades[0] != 0xff || shades[3] >= shades[2] {
+		t.Errorf("shades of colors 0-3: % x", shades)
+	}
+}
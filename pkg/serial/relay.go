@@ -0,0 +1,120 @@
+package serial
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Relay server
+//
+// Clients behind NAT connect out to the relay and send relayMagic, length of room code(1) and room code.
+// When two clients join the same room, relay sends relayPaired to both and forwards the traffic between them.
+// Link cable protocol (handshake, transfers) runs over the forwarded connection.
+const (
+	relayMagic    = "WWRL"
+	relayPaired   = 0x01
+	maxRoomLen    = 32
+	relayQueueLen = 256 // chunks buffered in each direction
+)
+
+// RelayServer pairs clients by room code
+type RelayServer struct {
+	mutex sync.Mutex
+	rooms map[string]net.Conn // client waiting for the peer
+}
+
+// NewRelayServer returns empty relay server
+func NewRelayServer() *RelayServer {
+	return &RelayServer{rooms: map[string]net.Conn{}}
+}
+
+// Serve accepts clients until ${l} is closed
+func (r *RelayServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go r.join(conn)
+	}
+}
+
+func (r *RelayServer) join(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	header := make([]byte, len(relayMagic)+1)
+	if _, err := io.ReadFull(conn, header); err != nil || string(header[:len(relayMagic)]) != relayMagic {
+		conn.Close()
+		return
+	}
+	room := make([]byte, header[len(relayMagic)])
+	if len(room) == 0 || len(room) > maxRoomLen {
+		conn.Close()
+		return
+	}
+	if _, err := io.ReadFull(conn, room); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	r.mutex.Lock()
+	peer, ok := r.rooms[string(room)]
+	if !ok {
+		r.rooms[string(room)] = conn
+		r.mutex.Unlock()
+		log.Printf("room %q: %s is waiting", room, conn.RemoteAddr())
+		return
+	}
+	delete(r.rooms, string(room))
+	r.mutex.Unlock()
+
+	// the waiting client may have gone
+	if _, err := peer.Write([]byte{relayPaired}); err != nil {
+		peer.Close()
+		r.mutex.Lock()
+		r.rooms[string(room)] = conn
+		r.mutex.Unlock()
+		return
+	}
+	if _, err := conn.Write([]byte{relayPaired}); err != nil {
+		conn.Close()
+		peer.Close()
+		return
+	}
+
+	log.Printf("room %q: %s <-> %s", room, peer.RemoteAddr(), conn.RemoteAddr())
+	go forward(conn, peer)
+	forward(peer, conn)
+	log.Printf("room %q: closed", room)
+}
+
+// forward copies ${src} to ${dst}. Reading and writing are decoupled by queue so that a slow client doesn't stall the other.
+func forward(dst, src net.Conn) {
+	queue := make(chan []byte, relayQueueLen)
+	go func() {
+		defer close(queue)
+		for {
+			buf := make([]byte, 512)
+			n, err := src.Read(buf)
+			if n > 0 {
+				queue <- buf[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for buf := range queue {
+		if _, err := dst.Write(buf); err != nil {
+			break
+		}
+	}
+	src.Close()
+	dst.Close()
+	for range queue { // reader stops by closed src
+	}
+}
@@ -1,53 +1,71 @@
 package serial
 
-import (
-	"fmt"
-	"net"
-	"sync"
-)
-
 // Serial シリアル通信情報を管理する構造体
 type Serial struct {
-	working bool
 	SB      byte
 	SC      byte
-	// ポート
-	MyIP     net.IP
-	MyPort   string
-	PeerIP   net.IP
-	PeerPort string
-	// その他
-	TransferFlag int
-	buf          byte
-	received     chan int
-	// 制御関連
-	Wait    sync.WaitGroup
-	WaitCtr int
-
-	mutex *sync.Mutex
-}
-
-// Init set IP addr
-func (serial *Serial) Init(myIP, myPort, peerIP, peerPort string, received chan int, mutex *sync.Mutex) {
-	serial.working = true
-	serial.MyIP = net.ParseIP(myIP)
-	serial.MyPort = myPort
-	serial.PeerIP = net.ParseIP(peerIP)
-	serial.PeerPort = peerPort
-	serial.received = received
-
-	serial.mutex = mutex
-
-	listen, err := net.Listen("tcp", fmt.Sprintf("%s:%s", serial.MyIP, serial.MyPort))
-	if err != nil {
-		return
+	bits    int    // remaining bits in transfer
+	fast    bool   // CGB high speed clock. With external clock, it's the master's clock.
+	in      byte   // byte being shifted in
+	sent    bool   // SB is sent to the device in this transfer
+	pending bool   // the device hasn't replied yet
+	since   uint64 // when the transfer started waiting for the reply
+	device  SerialDevice
+}
+
+// Timeout - M-cycles to wait for the reply to transfer with internal clock (about 0.5s in normal speed). Then 0xff is shifted in.
+const Timeout = 1 << 19
+
+// Port - Game Boy side of link port. Its methods must be called in the emulation goroutine.
+type Port interface {
+	// Receive starts the transfer which waits for external clock and returns the byte shifted out.
+	// ${in} is shifted into SB at the pace of the master's clock (${fast}: CGB high speed).
+	// ok is false if this Game Boy isn't waiting for external clock.
+	Receive(in byte, fast bool) (out byte, ok bool)
+	// Waiting returns true if this Game Boy is waiting for external clock
+	Waiting() bool
+	// Reply completes the transfer with internal clock which Exchange left pending. ${in} is shifted into SB.
+	Reply(in byte)
+	// Pending returns true while the transfer with internal clock waits for Reply
+	Pending() bool
+}
+
+// SerialDevice - peripheral or another Game Boy connected to link port
+type SerialDevice interface {
+	// Attach is called when the device is connected to ${port}
+	Attach(port Port)
+	// Exchange is called at the first clock edge when this Game Boy drives the clock (internal clock).
+	// It sends ${out} and returns the byte shifted in. ${fast} is true in CGB high speed mode (SC bit1).
+	// ok is false if the reply comes later. Then the transfer is pending until the device calls Port.Reply in Poll.
+	Exchange(out byte, fast bool) (in byte, ok bool)
+	// Poll is called periodically in the emulation goroutine. A device which drives the clock
+	// (external clock for this Game Boy) completes transfer by Port.Receive, and pending transfer is completed by Port.Reply.
+	Poll()
+	Close() error
+}
+
+// Connect connects ${device} to link port and disconnects the previous one
+func (serial *Serial) Connect(device SerialDevice, port Port) {
+	if serial.device != nil {
+		serial.device.Close()
 	}
+	serial.device = device
+	device.Attach(port)
+}
 
-	go serial.listen(&listen)
+// Device returns the connected device
+func (serial *Serial) Device() SerialDevice {
+	if serial.device == nil {
+		return Disconnected{}
+	}
+	return serial.device
 }
 
 // Exit close connection
 func (serial *Serial) Exit() {
+	if serial.device != nil {
+		serial.device.Close()
+	}
 }
 
 // ReadSB serial bus data
@@ -66,76 +84,80 @@ func (serial *Serial) ReadSC() byte {
 }
 
 // WriteSC serial control data
+// Writing SC aborts the transfer in progress.
 func (serial *Serial) WriteSC(value byte) {
 	serial.SC = value
+	serial.bits, serial.pending = 0, false
 }
 
-// Transfer transfer data by master
-func (serial *Serial) Transfer(ctr int) bool {
-	send := serial.SB
-
-	if !serial.working {
-		serial.SB = 0xff
-		return true
-	}
-
-	if serial.MyIP != nil && serial.PeerIP != nil {
+// Start starts 8 bits transfer with internal clock
+func (serial *Serial) Start(fast bool) {
+	serial.bits, serial.fast = 8, fast
+	serial.sent, serial.pending = false, false
+}
 
-		conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", serial.PeerIP, serial.PeerPort))
-		if err != nil {
-			fmt.Println(err.Error())
-			serial.SB = 0xff
-			return true
+// Shift shifts 1 bit at ${now} and returns true when the transfer is completed.
+// The byte is exchanged with the device at the first clock edge. If the device replies later, no bit is shifted while Pending.
+func (serial *Serial) Shift(now uint64) bool {
+	if !serial.sent {
+		serial.sent = true
+		in, ok := serial.Device().Exchange(serial.SB, serial.fast)
+		if !ok {
+			serial.pending, serial.since = true, now
+			return false
 		}
-		defer conn.Close()
-
-		serial.mutex.Lock()
-
-		conn.Write([]byte{send, byte(ctr)})
-
-		buf := make([]byte, 2)
-		conn.Read(buf)
-		read := buf[0]
-		serial.buf = read
-
-		serial.mutex.Unlock()
-
-		return true
+		serial.in = in
 	}
-
-	serial.SB = 0xff
+	if serial.pending {
+		return false
+	}
+	serial.SB = serial.SB<<1 | serial.in>>7
+	serial.in <<= 1
+	serial.bits--
+	if serial.bits > 0 {
+		return false
+	}
+	serial.ClearSC()
 	return true
 }
 
-// Listen transfer data by slave
-func (serial *Serial) listen(listen *net.Listener) {
-	for {
-		if serial.working && serial.MyIP != nil && serial.PeerIP != nil {
-			serial.Wait.Add(1)
-			serial.WaitCtr++
-			conn, _ := (*listen).Accept()
-
-			serial.Wait.Wait()
-
-			serial.mutex.Lock()
+// Pending returns true while the transfer with internal clock waits for the reply of the device
+func (serial *Serial) Pending() bool {
+	return serial.pending
+}
 
-			buf := make([]byte, 2)
-			conn.Read(buf)
-			read, ctr := buf[0], buf[1]
-			serial.buf = read
+// Reply is the late reply to the pending transfer
+func (serial *Serial) Reply(in byte) {
+	if serial.pending {
+		serial.in, serial.pending = in, false
+	}
+}
 
-			conn.Write([]byte{serial.SB, ctr})
+// Wait polls the device while the transfer is pending and returns true when the transfer goes on.
+// If the device doesn't reply for Timeout, 0xff is shifted in.
+func (serial *Serial) Wait(now uint64) bool {
+	serial.Device().Poll()
+	if serial.pending && now-serial.since >= Timeout {
+		serial.Reply(0xff)
+	}
+	return !serial.pending
+}
 
-			serial.mutex.Unlock()
+// Fast returns true if the transfer is clocked by CGB high speed clock
+func (serial *Serial) Fast() bool {
+	return serial.fast
+}
 
-			serial.received <- 1
-			conn.Close()
-		}
-	}
+// WaitingExternal returns true if transfer with external clock is started and the master hasn't clocked it yet
+func (serial *Serial) WaitingExternal() bool {
+	return serial.SC&0x81 == 0x80 && serial.bits == 0
 }
 
-func (serial *Serial) Receive() {
-	serial.SB = serial.buf
+// Receive starts 8 bits transfer with external clock. ${in} is shifted in by Shift at the pace of the master's clock.
+func (serial *Serial) Receive(in byte, fast bool) (out byte) {
+	serial.bits, serial.fast, serial.in = 8, fast, in
+	serial.sent, serial.pending = true, false
+	return serial.SB
 }
 
 func (serial *Serial) ClearSC() {
@@ -0,0 +1,65 @@
+package serial
+
+import "testing"
+
+// silent - device which never replies
+type silent struct{ Disconnected }
+
+func (silent) Exchange(out byte, fast bool) (byte, bool) { return 0, false }
+
+func TestSerialTimeout(t *testing.T) {
+	s := &Serial{SB: 0x42, SC: 0x81}
+	s.Connect(silent{}, &testPort{})
+	s.Start(false)
+
+	start := uint64(1000)
+	if s.Shift(start) || !s.Pending() {
+		t.Fatal("transfer isn't pending without reply")
+	}
+	if s.Wait(start + Timeout - 1) {
+		t.Fatal("transfer goes on before timeout")
+	}
+	if !s.Wait(start + Timeout) {
+		t.Fatal("transfer is still pending after timeout")
+	}
+
+	for i := 1; i <= 8; i++ {
+		if done := s.Shift(start + Timeout); done != (i == 8) {
+			t.Fatalf("bit %d: transfer completed: %v", i, done)
+		}
+	}
+	if s.SB != 0xff || s.SC != 0x01 {
+		t.Errorf("SB: %02x, SC: %02x after timeout, want ff, 01", s.SB, s.SC)
+	}
+
+	// late reply is ignored
+	s.Reply(0x00)
+	if s.SB != 0xff {
+		t.Errorf("late reply changes SB to %02x", s.SB)
+	}
+}
+
+func TestSerialReceive(t *testing.T) {
+	for _, fast := range []bool{false, true} {
+		s := &Serial{SB: 0x42, SC: 0x80}
+		if !s.WaitingExternal() {
+			t.Fatal("SC=0x80 doesn't wait for external clock")
+		}
+		if out := s.Receive(0x5a, fast); out != 0x42 {
+			t.Errorf("shifted out %02x, want 42", out)
+		}
+		if s.WaitingExternal() || s.Fast() != fast {
+			t.Errorf("fast %v: waiting: %v, fast: %v after master clocks", fast, s.WaitingExternal(), s.Fast())
+		}
+
+		// the byte is shifted in bit by bit at the pace of the master's clock
+		for i := 1; i <= 8; i++ {
+			if done := s.Shift(0); done != (i == 8) {
+				t.Fatalf("bit %d: transfer completed: %v", i, done)
+			}
+		}
+		if s.SB != 0x5a || s.SC != 0x00 {
+			t.Errorf("SB: %02x, SC: %02x, want 5a, 00", s.SB, s.SC)
+		}
+	}
+}
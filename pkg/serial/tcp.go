@@ -0,0 +1,531 @@
+package serial
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Link cable protocol
+//
+// Both sides listen on ${your} and dial ${peer}, or both connect to relay server. After handshake, one persistent connection is kept.
+// The Game Boy which drives the clock (internal clock) is master of the transfer. It sends msgTransfer
+// and the slave answers msgReply with the same sequence number when its game is ready (external clock).
+// flagFast tells the slave to shift the byte in at the pace of CGB high speed clock.
+// Infrared LED edges are sent as msgIR with M-cycles since the previous edge in seq, and the peer replays them at the same pace.
+const (
+	protocolVersion = 2
+	helloMagic      = "WWLK"
+)
+
+// frame flags
+const (
+	flagFast = 1 << iota // master uses CGB high speed clock
+)
+
+// frame kinds
+const (
+	msgTransfer = iota + 1 // master -> slave
+	msgReply               // slave -> master
+	msgPing                // keepalive
+	msgIR                  // infrared LED on(1)/off(0)
+)
+
+const (
+	frameSize        = 5 // kind, flags, seq(2), data
+	dialInterval     = time.Second
+	handshakeTimeout = 5 * time.Second
+	pingInterval     = time.Second
+	idleTimeout      = 5 * time.Second // cable is disconnected if nothing arrives in the meantime
+	irQueueLen       = 256
+	irLate           = 1024 // edge which arrives later than this (M-cycles) is applied from now on
+)
+
+// Hello - handshake information
+type Hello struct {
+	ROMHash [32]byte // sha256
+	Version string   // emulator version
+}
+
+type frame struct {
+	kind  byte
+	flags byte
+	seq   uint16
+	data  byte
+}
+
+// TCP - link cable over persistent TCP connection
+type TCP struct {
+	port     Port
+	hello    Hello
+	nonce    uint64 // decides which connection is kept when both sides dial
+	listener net.Listener
+	peer     string                   // peer address for direct connection
+	connect  func() (net.Conn, error) // dials peer or relay server
+
+	mutex    sync.Mutex
+	conn     *tcpConn
+	seq      uint16 // sequence number of the last transfer as master
+	out      byte   // byte sent in the last transfer as master
+	requests chan frame
+	replies  chan frame
+	pending  *frame // request which waits for this Game Boy to be ready
+	done     chan struct{}
+	closed   bool
+	waiting  net.Conn // connection which is waiting for the peer on relay server
+
+	edges  chan frame // infrared edges from the peer
+	irNext *frame     // edge which waits for its time
+	irAt   uint64     // emulated time when the last edge is applied
+	irSent uint64     // emulated time when the last edge is sent
+	light  bool
+}
+
+type tcpConn struct {
+	net.Conn
+	wmutex   sync.Mutex
+	lastSeen time.Time // guarded by TCP.mutex
+}
+
+// NewTCP listens on ${your} and connects to ${peer}. If ${peer} is empty, it only waits for connection.
+func NewTCP(your, peer string, hello Hello) (*TCP, error) {
+	listener, err := net.Listen("tcp", your)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTCP(hello)
+	t.listener, t.peer = listener, peer
+	go t.accept()
+	if peer != "" {
+		t.connect = func() (net.Conn, error) {
+			return net.DialTimeout("tcp", peer, handshakeTimeout)
+		}
+		go t.dial()
+	}
+	go t.keepalive()
+	return t, nil
+}
+
+// DialRelay connects to relay server and waits for the peer which uses the same ${room} code
+func DialRelay(relay, room string, hello Hello) (*TCP, error) {
+	if room == "" || len(room) > maxRoomLen {
+		return nil, fmt.Errorf("room code must be 1-%d characters", maxRoomLen)
+	}
+
+	t := newTCP(hello)
+	t.connect = func() (net.Conn, error) {
+		return t.joinRoom(relay, room)
+	}
+	go t.dial()
+	go t.keepalive()
+	return t, nil
+}
+
+func newTCP(hello Hello) *TCP {
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	return &TCP{
+		hello:    hello,
+		nonce:    binary.BigEndian.Uint64(nonce[:]),
+		requests: make(chan frame, 1),
+		replies:  make(chan frame, 16),
+		edges:    make(chan frame, irQueueLen),
+		done:     make(chan struct{}),
+	}
+}
+
+// joinRoom connects to relay server and blocks until the peer joins the room
+func (t *TCP) joinRoom(relay, room string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", relay, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	closed := t.closed
+	t.waiting = conn
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		t.waiting = nil
+		t.mutex.Unlock()
+	}()
+	if closed {
+		conn.Close()
+		return nil, errors.New("closed")
+	}
+
+	if _, err := conn.Write(append([]byte(relayMagic), append([]byte{byte(len(room))}, room...)...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var ready [1]byte
+	if _, err := io.ReadFull(conn, ready[:]); err != nil || ready[0] != relayPaired {
+		conn.Close()
+		return nil, errors.New("relay server refused")
+	}
+	return conn, nil
+}
+
+// Addr returns listening address
+func (t *TCP) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Connected returns false while the cable is disconnected
+func (t *TCP) Connected() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.conn != nil
+}
+
+func (t *TCP) isDone() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *TCP) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil { // closed
+			return
+		}
+		go t.open(conn, false)
+	}
+}
+
+// dial keeps dialing peer while disconnected
+func (t *TCP) dial() {
+	for !t.isDone() {
+		if !t.Connected() {
+			if conn, err := t.connect(); err == nil {
+				t.open(conn, true)
+			}
+		}
+		select {
+		case <-t.done:
+		case <-time.After(dialInterval):
+		}
+	}
+}
+
+// open does handshake and uses the connection
+func (t *TCP) open(conn net.Conn, dialed bool) {
+	peerNonce, peerDials, err := t.handshake(conn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Serial: handshake with %s failed: %s\n", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	// if both sides dial each other, keep the connection dialed by the side with larger nonce
+	if t.peer != "" && peerDials && (dialed != (t.nonce > peerNonce)) {
+		conn.Close()
+		return
+	}
+
+	c := &tcpConn{Conn: conn, lastSeen: time.Now()}
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	old := t.conn
+	t.conn = c
+	t.mutex.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	fmt.Fprintf(os.Stderr, "Serial: connected to %s\n", conn.RemoteAddr())
+	t.read(c)
+}
+
+// hello: magic(4), protocol version(1), flags(1), nonce(8), ROM hash(32), length of emulator version(1), emulator version
+// flags bit0: the side also dials directly
+func (t *TCP) handshake(conn net.Conn) (nonce uint64, dials bool, err error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var buf bytes.Buffer
+	buf.WriteString(helloMagic)
+	buf.WriteByte(protocolVersion)
+	flags := byte(0)
+	if t.peer != "" {
+		flags |= 1
+	}
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, t.nonce)
+	buf.Write(t.hello.ROMHash[:])
+	version := t.hello.Version
+	if len(version) > 0xff {
+		version = version[:0xff]
+	}
+	buf.WriteByte(byte(len(version)))
+	buf.WriteString(version)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return 0, false, err
+	}
+
+	header := make([]byte, 4+1+1+8+32+1)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, false, err
+	}
+	if string(header[:4]) != helloMagic {
+		return 0, false, errors.New("not a link cable")
+	}
+	if header[4] != protocolVersion {
+		return 0, false, fmt.Errorf("protocol version mismatch: %d != %d", header[4], protocolVersion)
+	}
+	dials = header[5]&1 != 0
+	nonce = binary.BigEndian.Uint64(header[6:])
+	if nonce == t.nonce {
+		return 0, false, errors.New("connected to itself")
+	}
+	peerVersion := make([]byte, header[46])
+	if _, err := io.ReadFull(conn, peerVersion); err != nil {
+		return 0, false, err
+	}
+
+	if !bytes.Equal(header[14:46], t.hello.ROMHash[:]) {
+		fmt.Fprintf(os.Stderr, "Serial: peer is running different ROM\n")
+	}
+	if string(peerVersion) != t.hello.Version {
+		fmt.Fprintf(os.Stderr, "Serial: peer emulator version is %s\n", peerVersion)
+	}
+	return nonce, dials, nil
+}
+
+func (t *TCP) read(c *tcpConn) {
+	buf := make([]byte, frameSize)
+	for {
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.disconnect(c)
+			return
+		}
+		f := frame{kind: buf[0], flags: buf[1], seq: binary.BigEndian.Uint16(buf[2:]), data: buf[4]}
+
+		t.mutex.Lock()
+		c.lastSeen = time.Now()
+		t.mutex.Unlock()
+
+		switch f.kind {
+		case msgTransfer:
+			select { // newer request replaces the old one
+			case <-t.requests:
+			default:
+			}
+			select {
+			case t.requests <- f:
+			default:
+			}
+		case msgReply:
+			select {
+			case t.replies <- f:
+			default:
+			}
+		case msgIR:
+			select {
+			case t.edges <- f:
+			default:
+			}
+		}
+	}
+}
+
+func (t *TCP) disconnect(c *tcpConn) {
+	c.Close()
+	t.mutex.Lock()
+	current := t.conn == c
+	if current {
+		t.conn = nil
+	}
+	t.mutex.Unlock()
+	if current && !t.isDone() {
+		fmt.Fprintf(os.Stderr, "Serial: cable disconnected\n")
+	}
+}
+
+// keepalive sends ping and drops the connection which is silent for idleTimeout
+func (t *TCP) keepalive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+
+		t.mutex.Lock()
+		c := t.conn
+		idle := c != nil && time.Since(c.lastSeen) > idleTimeout
+		t.mutex.Unlock()
+		if c == nil {
+			continue
+		}
+		if idle {
+			t.disconnect(c)
+			continue
+		}
+		t.send(frame{kind: msgPing})
+	}
+}
+
+func (t *TCP) send(f frame) bool {
+	t.mutex.Lock()
+	c := t.conn
+	t.mutex.Unlock()
+	if c == nil {
+		return false
+	}
+
+	buf := [frameSize]byte{f.kind, f.flags, byte(f.seq >> 8), byte(f.seq), f.data}
+	c.wmutex.Lock()
+	c.SetWriteDeadline(time.Now().Add(idleTimeout))
+	_, err := c.Write(buf[:])
+	c.wmutex.Unlock()
+	if err != nil {
+		t.disconnect(c)
+		return false
+	}
+	return true
+}
+
+func (t *TCP) Attach(port Port) { t.port = port }
+
+// Exchange sends ${out} as master. The transfer is completed in Poll when the reply arrives.
+// 0xff is shifted in at once if the cable is disconnected.
+func (t *TCP) Exchange(out byte, fast bool) (byte, bool) {
+	t.seq++
+	t.out = out
+	f := frame{kind: msgTransfer, seq: t.seq, data: out}
+	if fast {
+		f.flags |= flagFast
+	}
+	if !t.send(f) {
+		return 0xff, true
+	}
+	return 0, false
+}
+
+// Poll completes the pending transfer as master, and replies to the peer's transfer when this Game Boy is waiting for external clock
+func (t *TCP) Poll() {
+	if t.port == nil {
+		return
+	}
+	if t.port.Pending() {
+		t.pollReply()
+	}
+
+	select {
+	case f := <-t.requests:
+		t.pending = &f
+	default:
+	}
+	if t.pending == nil {
+		return
+	}
+	if t.port.Pending() {
+		// both sides drive the clock at the same time. each side gets the other's byte
+		t.send(frame{kind: msgReply, seq: t.pending.seq, data: t.out})
+		t.pending = nil
+		return
+	}
+	if out, ok := t.port.Receive(t.pending.data, t.pending.flags&flagFast != 0); ok {
+		t.send(frame{kind: msgReply, seq: t.pending.seq, data: out})
+		t.pending = nil
+	}
+}
+
+// pollReply passes the reply to the last transfer to port. Late replies to the old transfers are dropped.
+func (t *TCP) pollReply() {
+	for {
+		select {
+		case f := <-t.replies:
+			if f.seq == t.seq {
+				t.port.Reply(f.data)
+				return
+			}
+		default:
+			if !t.Connected() { // reply never comes
+				t.port.Reply(0xff)
+			}
+			return
+		}
+	}
+}
+
+// SetLED sends infrared edge with the time since the previous one
+func (t *TCP) SetLED(on bool, now uint64) {
+	delta := now - t.irSent
+	if delta > 0xffff {
+		delta = 0xffff
+	}
+	t.irSent = now
+	f := frame{kind: msgIR, seq: uint16(delta)}
+	if on {
+		f.data = 1
+	}
+	t.send(f)
+}
+
+// Light applies the peer's edges whose time has come
+func (t *TCP) Light(now uint64) bool {
+	for {
+		if t.irNext == nil {
+			select {
+			case f := <-t.edges:
+				t.irNext = &f
+			default:
+				return t.light
+			}
+		}
+
+		f := t.irNext
+		due := t.irAt + uint64(f.seq)
+		if now < due {
+			return t.light
+		}
+		t.irAt = due
+		if now-due > irLate { // the first edge after a gap, or delayed by network
+			t.irAt = now
+		}
+		t.light, t.irNext = f.data != 0, nil
+	}
+}
+
+func (t *TCP) Close() error {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+	c, waiting := t.conn, t.waiting
+	t.conn = nil
+	t.mutex.Unlock()
+
+	if c != nil {
+		c.Close()
+	}
+	if waiting != nil {
+		waiting.Close()
+	}
+	if t.listener != nil {
+		return t.listener.Close()
+	}
+	return nil
+}
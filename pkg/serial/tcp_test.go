@@ -0,0 +1,284 @@
+package serial
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// testPort - Game Boy which waits for external clock with ${sb}, or waits for the reply to the transfer with internal clock
+type testPort struct {
+	sb       byte
+	waiting  bool
+	received []byte
+	fast     []bool // master's clock of each received byte
+	pending  bool
+	reply    byte
+}
+
+func (p *testPort) Receive(in byte, fast bool) (byte, bool) {
+	if !p.waiting {
+		return 0, false
+	}
+	out := p.sb
+	p.received = append(p.received, in)
+	p.fast = append(p.fast, fast)
+	p.waiting = false
+	return out, true
+}
+
+func (p *testPort) Waiting() bool { return p.waiting }
+func (p *testPort) Pending() bool { return p.pending }
+
+func (p *testPort) Reply(in byte) {
+	if p.pending {
+		p.reply, p.pending = in, false
+	}
+}
+
+// exchange sends ${out} as master and polls ${link} until the reply arrives
+func exchange(t *testing.T, link *TCP, port *testPort, out byte, fast bool) byte {
+	if in, ok := link.Exchange(out, fast); ok {
+		return in
+	}
+	port.pending = true
+	deadline := time.Now().Add(5 * time.Second)
+	for port.pending {
+		if time.Now().After(deadline) {
+			t.Fatal("master doesn't receive reply")
+		}
+		link.Poll()
+		time.Sleep(time.Millisecond)
+	}
+	return port.reply
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitConnected(t *testing.T, links ...*TCP) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, l := range links {
+		for !l.Connected() {
+			if time.Now().After(deadline) {
+				t.Fatal("link cable isn't connected")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// newPair connects two instances over loopback. Both sides dial each other.
+func newPair(t *testing.T, helloA, helloB Hello) (*TCP, *TCP) {
+	addrA, addrB := freeAddr(t), freeAddr(t)
+	a, err := NewTCP(addrA, addrB, helloA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewTCP(addrB, addrA, helloB)
+	if err != nil {
+		a.Close()
+		t.Fatal(err)
+	}
+	waitConnected(t, a, b)
+	return a, b
+}
+
+// slave runs emulation loop of the slave side until ${stop} is closed
+func slave(link *TCP, port *testPort, data []byte, stop chan struct{}) chan struct{} {
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		i := 0
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if !port.waiting && i < len(data) {
+				port.sb, port.waiting = data[i], true // game writes SB and SC=0x80
+				i++
+			}
+			link.Poll()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return finished
+}
+
+func TestTCPExchange(t *testing.T) {
+	hello := Hello{Version: "test"}
+	a, b := newPair(t, hello, hello)
+	defer a.Close()
+	defer b.Close()
+
+	portA, portB := &testPort{}, &testPort{}
+	a.Attach(portA)
+	b.Attach(portB)
+
+	sent := []byte{0x01, 0x02, 0xfe, 0x00, 0x55}
+	replies := []byte{0x10, 0x20, 0xef, 0xff, 0xaa}
+	stop := make(chan struct{})
+	finished := slave(b, portB, replies, stop)
+
+	for i, out := range sent {
+		if in := exchange(t, a, portA, out, false); in != replies[i] {
+			t.Errorf("transfer %d: master received %02x, want %02x", i, in, replies[i])
+		}
+	}
+	close(stop)
+	<-finished
+
+	if string(portB.received) != string(sent) {
+		t.Errorf("slave received % x, want % x", portB.received, sent)
+	}
+	for i, fast := range portB.fast {
+		if fast {
+			t.Errorf("transfer %d: slave is clocked by high speed clock", i)
+		}
+	}
+}
+
+func TestTCPTimeout(t *testing.T) {
+	a, b := newPair(t, Hello{}, Hello{})
+	defer a.Close()
+	defer b.Close()
+
+	portA := &testPort{}
+	a.Attach(portA)
+	b.Attach(&testPort{}) // slave isn't waiting for external clock
+	if _, ok := a.Exchange(0x42, false); ok {
+		t.Fatal("transfer is completed without reply")
+	}
+	portA.pending = true
+	for i := 0; i < 50; i++ { // emulation goes on while master waits
+		a.Poll()
+		b.Poll()
+		time.Sleep(time.Millisecond)
+	}
+	if !portA.pending {
+		t.Fatalf("master received %02x from slave which isn't waiting", portA.reply)
+	}
+	portA.pending = false // Serial gives up after Timeout
+
+	// late reply to the old transfer must be ignored
+	portB := &testPort{sb: 0x33, waiting: true}
+	b.Attach(portB)
+	b.Poll()
+	stop := make(chan struct{})
+	finished := slave(b, portB, []byte{0x77}, stop)
+	if in := exchange(t, a, portA, 0x43, false); in != 0x77 {
+		t.Errorf("master received %02x, want 77", in)
+	}
+	close(stop)
+	<-finished
+}
+
+func TestTCPDisconnect(t *testing.T) {
+	a, b := newPair(t, Hello{ROMHash: [32]byte{1}}, Hello{ROMHash: [32]byte{2}}) // different ROMs can be linked
+	defer a.Close()
+
+	b.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("disconnection isn't detected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if in, ok := a.Exchange(0x42, false); !ok || in != 0xff {
+		t.Errorf("master received %02x (ok: %v) while disconnected, want ff at once", in, ok)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go NewRelayServer().Serve(l)
+
+	a, err := DialRelay(l.Addr().String(), "ROOM1", Hello{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	other, err := DialRelay(l.Addr().String(), "ROOM2", Hello{}) // doesn't join room1
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	b, err := DialRelay(l.Addr().String(), "ROOM1", Hello{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	waitConnected(t, a, b)
+	if other.Connected() {
+		t.Error("client in another room is connected")
+	}
+
+	portA, portB := &testPort{}, &testPort{}
+	a.Attach(portA)
+	b.Attach(portB)
+	stop := make(chan struct{})
+	finished := slave(b, portB, []byte{0x12, 0x34}, stop)
+	for i, want := range []byte{0x12, 0x34} {
+		if in := exchange(t, a, portA, byte(i), true); in != want {
+			t.Errorf("transfer %d: master received %02x, want %02x", i, in, want)
+		}
+	}
+	close(stop)
+	<-finished
+	if len(portB.fast) != 2 || !portB.fast[0] || !portB.fast[1] {
+		t.Errorf("slave is clocked by high speed clock: %v, want [true true]", portB.fast)
+	}
+}
+
+func TestTCPInfrared(t *testing.T) {
+	a, b := newPair(t, Hello{}, Hello{})
+	defer a.Close()
+	defer b.Close()
+
+	var sender, receiver Infrared
+	sender.Connect(a)
+	receiver.Connect(b)
+	receiver.WriteRP(0xc0, 0) // enable reading
+
+	// pulses of 100, 50 and 200 M-cycles from time 1000
+	edges := []uint64{1000, 1100, 1150, 1350}
+	for i, at := range edges {
+		sender.WriteRP(byte(i+1)&0x01, at)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.edges) < len(edges) {
+		if time.Now().After(deadline) {
+			t.Fatal("infrared edges don't arrive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// receiver starts replaying at its own time 5000
+	start := uint64(5000)
+	for now := start; now < start+500; now++ {
+		on := (now >= start && now < start+100) || (now >= start+150 && now < start+350)
+		if light := receiver.ReadRP(now)&0x02 == 0; light != on {
+			t.Fatalf("at +%d: light is %v, want %v", now-start, light, on)
+		}
+	}
+
+	receiver.WriteRP(0x00, start+500) // reading is disabled
+	if rp := receiver.ReadRP(start + 500); rp != 0x3e {
+		t.Errorf("RP is %02x while reading is disabled, want 3e", rp)
+	}
+}